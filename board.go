@@ -13,6 +13,12 @@ import (
 
 const MaxBoardLen = 2217
 
+// ErrTooLarge is returned for boards larger than MaxBoardLen
+var ErrTooLarge = errors.New("Invalid Board: too large")
+
+// ErrInvalidSignature is returned when content fails signature verification
+var ErrInvalidSignature = errors.New("Invalid Signature")
+
 type Board struct {
 	Publisher Publisher
 	timestamp time.Time
@@ -44,6 +50,11 @@ func (b Board) Timestamp() string {
 	return b.timestamp.Format(http.TimeFormat)
 }
 
+// Time returns the timestamp parsed from the board's time element.
+func (b Board) Time() time.Time {
+	return b.timestamp
+}
+
 func (b Board) Signature() string {
 	return b.signature.String()
 }
@@ -79,14 +90,14 @@ func NewBoard(key string, sig Signature, content []byte) (Board, error) {
 	}
 	// validate size requirement
 	if len(content) > MaxBoardLen {
-		return Board{}, errors.New("Invalid Board: too large")
+		return Board{}, ErrTooLarge
 	}
 	board.Content = content
 
 	// validate signature (can we trust the content)
 	board.signature = sig
 	if !board.VerifySignature() {
-		return Board{}, errors.New("Invalid Signature")
+		return Board{}, ErrInvalidSignature
 	}
 
 	// validate "last-modified meta tag"
@@ -106,10 +117,13 @@ func BoardFromHTTP(key string, auth string, body io.ReadCloser) (Board, error) {
 	if err != nil {
 		return Board{}, err
 	}
-	// Content
-	content, err := io.ReadAll(body)
+	// Content (never read more than one byte past the largest valid board)
+	content, err := io.ReadAll(io.LimitReader(body, MaxBoardLen+1))
 	if err != nil {
 		return Board{}, err
 	}
+	if len(content) > MaxBoardLen {
+		return Board{}, ErrTooLarge
+	}
 	return NewBoard(key, sig, content)
 }
@@ -0,0 +1,96 @@
+package s83
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+)
+
+// ChangesPath lists the boards changed on a server since a cursor, so peers
+// and mirrors can replicate without probing every key.
+const ChangesPath = "/changes"
+
+// limit on the size of a page of changes read from a server
+const maxChangesLen = 1024 * 1024
+
+// Change is a board changed (or removed) on a server. Seq is the server's
+// number for the change, fetch the board itself (e.g. with a Follow) to get
+// its content. A removal only has the key.
+type Change struct {
+	Seq       uint64 `json:"seq"`
+	Key       string `json:"key"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Removed   bool   `json:"removed,omitempty"`
+}
+
+// ChangesPage is a page of changes in the order they were made. Next is the
+// cursor for the following page and More is set if there are more changes.
+type ChangesPage struct {
+	Changes []Change `json:"changes"`
+	Next    uint64   `json:"next"`
+	More    bool     `json:"more"`
+}
+
+// GetChanges fetches a page of up to limit changes made after the cursor
+// since (0 for every board). A limit of 0 uses the server's default.
+func GetChanges(client *http.Client, server *url.URL, since uint64, limit int) (ChangesPage, error) {
+	changesURL := *server
+	changesURL.Path = path.Join(changesURL.Path, ChangesPath)
+	q := url.Values{}
+	q.Set("since", strconv.FormatUint(since, 10))
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	changesURL.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", changesURL.String(), nil)
+	if err != nil {
+		return ChangesPage{}, err
+	}
+	req.Header.Set("Spring-Version", SpringVersion)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return ChangesPage{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return ChangesPage{}, fmt.Errorf("Status code: %v", res.Status)
+	}
+
+	var page ChangesPage
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxChangesLen)).Decode(&page); err != nil {
+		return ChangesPage{}, err
+	}
+	return page, nil
+}
+
+// GetAllChanges follows pages of changes made after since until there are no
+// more (a page can be empty when the server left out every change in it). It
+// returns the changes and the cursor to resume from later.
+func GetAllChanges(client *http.Client, server *url.URL, since uint64) ([]Change, uint64, error) {
+	changes := []Change{}
+	for {
+		page, err := GetChanges(client, server, since, 0)
+		if err != nil {
+			return changes, since, err
+		}
+		changes = append(changes, page.Changes...)
+		if !page.More {
+			if page.Next > since {
+				since = page.Next
+			}
+			return changes, since, nil
+		}
+		if page.Next <= since {
+			return changes, since, fmt.Errorf("changes cursor did not advance past %d", since)
+		}
+		since = page.Next
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -35,27 +36,45 @@ func main() {
 
 	// Display configuration information (e.g. which "profile") is in use
 	whoCmd := flag.NewFlagSet("who", flag.ExitOnError)
+	serverInfoFlag := whoCmd.Bool("server", false, "also fetch and verify the server's signed info")
 
 	// Publish a board
 	// TODO: handle "delete" functionality, aka "tombstone" boards, "404 Not Found"
 	pubCmd := flag.NewFlagSet("pub", flag.ExitOnError)
 	dryFlag := pubCmd.Bool("dry", false, "dry run, print board locally instead of publishing")
+	inviteFlag := pubCmd.String("invite", "", "invite token to register with an invite only server")
 
 	// Get boards from a server
 	getCmd := flag.NewFlagSet("get", flag.ExitOnError)
 	outFlag := getCmd.String("o", "", "output your 'Daily Spring' to a specific path")
 	browseFlag := getCmd.Bool("go", false, "open your 'Daily Spring' in a browser")
 	newOnlyFlag := getCmd.Bool("new", false, "only get new boards")
+	atomFlag := getCmd.String("atom", "", "also write your follows as an Atom feed to a specific path")
 
-	cmdOrder := []string{"pub", "get", "new", "who"}
+	// Administer a server (requires the server's admin key as the secret)
+	adminCmd := flag.NewFlagSet("admin", flag.ExitOnError)
+	dataFlag := adminCmd.String("d", "", "body to send with the request")
+
+	// Create an invite to an invite only server (requires the admin key)
+	inviteCmd := flag.NewFlagSet("invite", flag.ExitOnError)
+	daysFlag := inviteCmd.Int("days", 7, "days before the invite expires")
+
+	// Search boards previously downloaded with get
+	searchCmd := flag.NewFlagSet("search", flag.ExitOnError)
+	limitFlag := searchCmd.Int("n", 10, "maximum number of results")
+
+	cmdOrder := []string{"pub", "get", "search", "new", "who", "admin", "invite"}
 	cmds := map[string]struct {
 		fs          *flag.FlagSet
 		description string
 	}{
-		"pub": {pubCmd, "publish a board"},
-		"get": {getCmd, "download follows/boards and make your 'Daily Spring'"},
-		"new": {newCmd, "generate a new keypair"},
-		"who": {whoCmd, "show profile information"},
+		"pub":    {pubCmd, "publish a board"},
+		"get":    {getCmd, "download follows/boards and make your 'Daily Spring'"},
+		"search": {searchCmd, "search the text of downloaded boards"},
+		"new":    {newCmd, "generate a new keypair"},
+		"who":    {whoCmd, "show profile information"},
+		"admin":  {adminCmd, "make a signed request to the server admin API"},
+		"invite": {inviteCmd, "create an invite token for a new publisher"},
 	}
 
 	flag.Usage = func() {
@@ -93,6 +112,14 @@ func main() {
 		fmt.Printf("  %-8s %s\n", "key", "get a single board")
 	}
 
+	searchCmd.Usage = func() {
+		fmt.Printf("%s: %s\n", "search", cmds["search"].description)
+		fmt.Println("\nusage: s83 search [flags] <words>")
+		fmt.Println("\nBoards matching every word are listed, best match first.")
+		fmt.Println("\nflags:")
+		searchCmd.PrintDefaults()
+	}
+
 	newCmd.Usage = func() {
 		fmt.Printf("%s: %s\n", "new", cmds["new"].description)
 		fmt.Println("\nusage: s83 new [flags]")
@@ -102,7 +129,28 @@ func main() {
 
 	whoCmd.Usage = func() {
 		fmt.Printf("%s: %s\n", "who", cmds["who"].description)
-		fmt.Println("\nusage: s83 who")
+		fmt.Println("\nusage: s83 who [flags]")
+		fmt.Println("\nflags:")
+		whoCmd.PrintDefaults()
+	}
+
+	adminCmd.Usage = func() {
+		fmt.Printf("%s: %s\n", "admin", cmds["admin"].description)
+		fmt.Println("\nusage: s83 admin [flags] <method> <path>")
+		fmt.Println("\nflags:")
+		adminCmd.PrintDefaults()
+		fmt.Println("\nexamples:")
+		fmt.Println("  s83 admin GET /admin/stats")
+		fmt.Println("  s83 admin PUT /admin/blocked/<key>")
+		fmt.Println("  s83 admin POST /admin/sweep")
+	}
+
+	inviteCmd.Usage = func() {
+		fmt.Printf("%s: %s\n", "invite", cmds["invite"].description)
+		fmt.Println("\nusage: s83 invite [flags]")
+		fmt.Println("\nThe token is signed by the profile secret, which must be the server's admin key.")
+		fmt.Println("\nflags:")
+		inviteCmd.PrintDefaults()
 	}
 
 	// parse global flags
@@ -124,7 +172,7 @@ func main() {
 
 	case "who":
 		whoCmd.Parse(subArgs)
-		config.Who()
+		config.Who(*serverInfoFlag)
 
 	case "pub":
 		pubCmd.Parse(subArgs)
@@ -147,7 +195,7 @@ func main() {
 			os.Exit(1)
 		}
 
-		config.Pub(pubCmd.Arg(0), *dryFlag)
+		config.Pub(pubCmd.Arg(0), *inviteFlag, *dryFlag)
 
 	case "get":
 		getCmd.Parse(subArgs)
@@ -156,7 +204,52 @@ func main() {
 			os.Exit(1)
 		}
 
-		config.Get(getCmd.Arg(0), *outFlag, *browseFlag, *newOnlyFlag)
+		config.Get(getCmd.Arg(0), *outFlag, *atomFlag, *browseFlag, *newOnlyFlag)
+
+	case "search":
+		searchCmd.Parse(subArgs)
+		if searchCmd.NArg() < 1 || *limitFlag < 1 {
+			searchCmd.Usage()
+			os.Exit(1)
+		}
+
+		config.Search(strings.Join(searchCmd.Args(), " "), *limitFlag)
+
+	case "admin":
+		adminCmd.Parse(subArgs)
+		if adminCmd.NArg() != 2 {
+			adminCmd.Usage()
+			os.Exit(1)
+		}
+
+		if config.Creator.PrivateKey == nil {
+			fmt.Println("[ERROR] missing secret configuration.")
+			fmt.Printf("[info] add the admin 'secret=' line to your config file (%s)\n", config.Path())
+			os.Exit(1)
+		}
+
+		if config.Server == nil {
+			fmt.Println("[ERROR] missing server configuration.")
+			fmt.Printf("[info] add a 'server=' line to your config file (%s)\n", config.Path())
+			os.Exit(1)
+		}
+
+		config.Admin(adminCmd.Arg(0), adminCmd.Arg(1), *dataFlag)
+
+	case "invite":
+		inviteCmd.Parse(subArgs)
+		if inviteCmd.NArg() != 0 || *daysFlag < 1 {
+			inviteCmd.Usage()
+			os.Exit(1)
+		}
+
+		if config.Creator.PrivateKey == nil {
+			fmt.Println("[ERROR] missing secret configuration.")
+			fmt.Printf("[info] add the admin 'secret=' line to your config file (%s)\n", config.Path())
+			os.Exit(1)
+		}
+
+		config.Invite(*daysFlag)
 
 	default:
 		fmt.Printf("invalid command\n\n")
@@ -187,11 +280,46 @@ func (config Config) New(j int) {
 	fmt.Println("secret:", c.Creator.ExportPrivateKey())
 }
 
-func (config Config) Who() {
+func (config Config) Who(serverInfo bool) {
 	fmt.Print(config)
+
+	if serverInfo {
+		if config.Server == nil {
+			exitOnError(errors.New("missing server configuration"))
+		}
+		info, err := s83.GetServerInfo(config.client, config.Server, config.ServerKey)
+		exitOnError(err)
+
+		// without a pinned key the info only proves the server holds the key it
+		// claims, not that it is the server expected
+		if config.ServerKey != "" {
+			fmt.Printf("---------\nserver  : %s (verified)\n", info.Key)
+		} else {
+			fmt.Printf("---------\nserver  : %s (not pinned)\n", info.Key)
+			fmt.Println("[info] Add `server_key = <key>` to your profile, once you trust it, to verify the server")
+		}
+		fmt.Printf("title   : %s\n", info.Title)
+		fmt.Printf("version : %s\n", info.SpringVersion)
+		fmt.Printf("ttl     : %d days\n", info.TTL)
+		fmt.Printf("max len : %d bytes\n", info.MaxBoardLen)
+		if info.AdminBoard != "" {
+			fmt.Printf("admin   : %s\n", info.AdminBoard)
+		}
+		for _, peer := range info.Peers {
+			fmt.Printf("peer    : %s\n", peer)
+		}
+		names := make([]string, 0, len(info.Policies))
+		for name := range info.Policies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("policy  : %s = %s\n", name, info.Policies[name])
+		}
+	}
 }
 
-func (config Config) Pub(path string, dryRun bool) {
+func (config Config) Pub(path string, invite string, dryRun bool) {
 	data, err := os.ReadFile(path)
 	exitOnError(err)
 
@@ -199,7 +327,7 @@ func (config Config) Pub(path string, dryRun bool) {
 	exitOnError(err)
 
 	if !dryRun {
-		exitOnError(publishBoard(config.Server, board))
+		exitOnError(publishBoard(config.client, config.Server, board, invite))
 	} else {
 		fmt.Println("[info] Success. This board should publish (pending TTL checks)")
 		fmt.Println("[info] Size: ", len(board.Content))
@@ -207,12 +335,11 @@ func (config Config) Pub(path string, dryRun bool) {
 	}
 }
 
-func publishBoard(server *url.URL, board s83.Board) error {
+func publishBoard(client *http.Client, server *url.URL, board s83.Board, invite string) error {
 
 	// add publisher key to URL
 	server.Path = path.Join(server.Path, board.Publisher.String())
 
-	client := &http.Client{}
 	req, err := http.NewRequest("PUT", server.String(), bytes.NewReader(board.Content))
 	exitOnError(err)
 
@@ -224,6 +351,11 @@ func publishBoard(server *url.URL, board s83.Board) error {
 	// TODO(?): If-Unmodified-Since: <date and time in UTC, HTTP (RFC 5322) format>
 	req.Header.Set("If-Unmodified-Since", board.Timestamp())
 
+	// register with an invite only server
+	if invite != "" {
+		req.Header.Set(s83.InviteHeader, invite)
+	}
+
 	// make request
 	res, err := client.Do(req)
 	exitOnError(err)
@@ -241,6 +373,40 @@ func publishBoard(server *url.URL, board s83.Board) error {
 	}
 }
 
+func (config Config) Admin(method string, adminPath string, data string) {
+	adminURL := *config.Server
+	adminURL.Path = path.Join(adminURL.Path, adminPath)
+
+	body := []byte(data)
+	req, err := http.NewRequest(strings.ToUpper(method), adminURL.String(), bytes.NewReader(body))
+	exitOnError(err)
+
+	req.Header.Set("Spring-Version", s83.SpringVersion)
+	config.Creator.SignRequest(req, body)
+
+	res, err := config.client.Do(req)
+	exitOnError(err)
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	exitOnError(err)
+
+	if res.StatusCode != http.StatusOK {
+		exitOnError(fmt.Errorf("%s: %s", res.Status, resBody))
+	}
+	fmt.Print(string(resBody))
+}
+
+func (config Config) Invite(days int) {
+	expires := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	token, err := config.Creator.NewInvite(expires)
+	exitOnError(err)
+
+	fmt.Printf("[info] Invite expires %s. It can be used once.\n", expires.UTC().Format(time.RFC1123))
+	fmt.Println("[info] Publish with: s83 pub -invite <token> <path>")
+	fmt.Println(token)
+}
+
 // TODO: realm/trust management
 // "If the signature is not valid,the client must drop the response and
 // remove the server from its list of trustworthy peers
@@ -251,7 +417,7 @@ func publishBoard(server *url.URL, board s83.Board) error {
 // TODO: the client may also scan for arbitrary data stored in
 // data-spring-* attributes throughout the board.
 
-func (config Config) Get(key string, outPath string, browse bool, newOnly bool) {
+func (config Config) Get(key string, outPath string, atomPath string, browse bool, newOnly bool) {
 	follows := config.Follows
 
 	// single key specified
@@ -281,7 +447,7 @@ func (config Config) Get(key string, outPath string, browse bool, newOnly bool)
 		}
 
 		// fetch board from server
-		b, err := f.GetBoard(modTimeStr)
+		b, err := f.GetBoardWithClient(config.client, modTimeStr)
 		if err != nil {
 			// TODO: improve error handling, actual checks not string inference
 			if strings.Contains(err.Error(), "304 Not Modified") {
@@ -339,6 +505,14 @@ func (config Config) Get(key string, outPath string, browse bool, newOnly bool)
 
 	fmt.Printf("[info] Published your 'Daily Spring' to: %s\n", outPath)
 
+	if atomPath != "" {
+		if err := config.writeAtom(newBoards, localBoards, follows, outPath, atomPath); err != nil {
+			fmt.Printf("[warn] Failed writing Atom feed: %v\n", err)
+		} else {
+			fmt.Printf("[info] Wrote an Atom feed of your follows to: %s\n", atomPath)
+		}
+	}
+
 	if browse {
 		err := openBrowserToPath(outPath)
 		if err != nil {
@@ -349,6 +523,37 @@ func (config Config) Get(key string, outPath string, browse bool, newOnly bool)
 	}
 }
 
+// Search lists downloaded boards matching a query, naming followed publishers
+func (config Config) Search(query string, limit int) {
+	results := config.store.Search(query, limit)
+	if len(results) == 0 {
+		fmt.Println("[info] no matching boards")
+		return
+	}
+
+	follows := map[string]s83.Follow{}
+	for _, f := range config.Follows {
+		follows[f.Key()] = f
+	}
+	for _, r := range results {
+		who := r.Board.Key()
+		if f, ok := follows[who]; ok {
+			who = f.String()
+		}
+		fmt.Printf("%s (%s, score %d)\n", who, r.Board.Timestamp(), r.Score)
+		fmt.Printf("  %s\n", searchExcerpt(s83.BoardText(r.Board.Content)))
+	}
+}
+
+// searchExcerpt shortens board text to fit on a line
+func searchExcerpt(text string) string {
+	runes := []rune(text)
+	if len(runes) <= 76 {
+		return text
+	}
+	return string(runes[:75]) + "…"
+}
+
 func openBrowserToPath(path string) error {
 	// TODO: generalize for other launchers/platform, and better error checking
 	cmd := exec.Command("xdg-open", path)
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/royragsdale/s83"
+)
+
+// testConfig parses a profile's config, with its data kept in a temporary
+// config directory
+func testConfig(t *testing.T, name string, data string) Config {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if err := os.MkdirAll(dataPath(name), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return parseConfig([]byte(data), name)
+}
+
+func testBoard(t *testing.T, content string) s83.Board {
+	t.Helper()
+	creator, err := s83.NewCreatorFromKey(s83.TestPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	board, err := creator.NewBoard([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return board
+}
+
+// boardServer serves boards the way a Spring '83 server does
+func boardServer(t *testing.T, boards ...s83.Board) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, b := range boards {
+			if req.URL.Path == "/"+b.Key() {
+				w.Header().Set("Spring-Signature", b.Signature())
+				w.Write(b.Content)
+				return
+			}
+		}
+		http.NotFound(w, req)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// captureStdout returns what fn prints
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-out
+}
+
+func TestGetAtom(t *testing.T) {
+	board := testBoard(t, "<p>spring atom</p>")
+	srv := boardServer(t, board)
+	config := testConfig(t, "atom", "server = "+srv.URL+"\nfriend\n"+srv.URL+"/"+board.Key())
+
+	dir := t.TempDir()
+	outPath, atomPath := filepath.Join(dir, "daily.html"), filepath.Join(dir, "daily.atom")
+	out := captureStdout(t, func() { config.Get("", outPath, atomPath, false, false) })
+	if !strings.Contains(out, "Wrote an Atom feed") {
+		t.Errorf("get: %s", out)
+	}
+
+	atom, err := os.ReadFile(atomPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<feed xmlns="http://www.w3.org/2005/Atom"`,
+		"<title>Daily Spring (atom)</title>",
+		fileURL(outPath),
+		"<title>" + board.Key() + "</title>",
+		srv.URL + "/" + board.Key(),
+		board.Signature(),
+		"spring atom",
+	} {
+		if !strings.Contains(string(atom), want) {
+			t.Errorf("atom feed missing %q:\n%s", want, atom)
+		}
+	}
+}
+
+func TestInvite(t *testing.T) {
+	admin, err := s83.NewCreatorFromKey(s83.TestPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an invite only server accepting invites signed by its admin
+	registered := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		invite, err := s83.ParseInvite(req.Header.Get(s83.InviteHeader), admin.Publisher)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		registered = append(registered, invite.ID)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	config := testConfig(t, "admin", "server = "+srv.URL+"\nsecret = "+s83.TestPrivate)
+
+	out := captureStdout(t, func() { config.Invite(3) })
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	token := lines[len(lines)-1]
+	invite, err := s83.ParseInvite(token, admin.Publisher)
+	if err != nil {
+		t.Fatalf("invite %q: %v", token, err)
+	}
+	if expires := time.Until(invite.Expires); expires < 71*time.Hour || expires > 72*time.Hour {
+		t.Errorf("invite should expire in 3 days: %v", invite.Expires)
+	}
+
+	board := testBoard(t, "<p>invited</p>")
+	server := *config.Server
+	captureStdout(t, func() { err = publishBoard(config.client, &server, board, token) })
+	if err != nil || len(registered) != 1 || registered[0] != invite.ID {
+		t.Errorf("publish with invite: %v (registered %v)", err, registered)
+	}
+	server = *config.Server
+	if err := publishBoard(config.client, &server, board, ""); err == nil {
+		t.Error("publish without an invite should be refused")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	board := testBoard(t, "<h1>Spring</h1><p>the first spring board</p>")
+	srv := boardServer(t, board)
+	config := testConfig(t, "search", "server = "+srv.URL+"\nfriend\n"+srv.URL+"/"+board.Key())
+	captureStdout(t, func() { config.Get("", filepath.Join(t.TempDir(), "daily.html"), "", false, false) })
+
+	out := captureStdout(t, func() { config.Search("SPRING board", 0) })
+	want := config.Follows[0].String() + " (" + board.Timestamp() + ", score 3)\n  Spring the first spring board\n"
+	if out != want {
+		t.Errorf("search: got %q want %q", out, want)
+	}
+
+	if out := captureStdout(t, func() { config.Search("autumn", 0) }); out != "[info] no matching boards\n" {
+		t.Errorf("search without matches: %q", out)
+	}
+}
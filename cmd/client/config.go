@@ -1,15 +1,19 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/royragsdale/s83"
 	"github.com/royragsdale/s83/store"
@@ -25,7 +29,11 @@ type Config struct {
 	Name      string
 	Creator   s83.Creator
 	Server    *url.URL
+	ServerKey string // the server's identity key, pinned to verify its info
 	Follows   []s83.Follow
+	CA        string // extra certificate authority to trust (e.g. a test server)
+	SignReads bool   // sign GET requests (for servers with private reads)
+	client    *http.Client
 	store     *store.Store
 	templates *template.Template
 	Favicon   string
@@ -117,6 +125,17 @@ func parseConfig(data []byte, name string) Config {
 		}
 	}
 
+	reServerKey := regexp.MustCompile(`(?m)^server_key\s*=\s*(.*)$`)
+	serverKeyMatch := reServerKey.FindSubmatch(data)
+	if serverKeyMatch != nil && len(serverKeyMatch) == 2 && len(serverKeyMatch[1]) > 0 {
+		serverKey := strings.TrimSpace(string(serverKeyMatch[1]))
+		if _, err := s83.NewPublisherFromKey(serverKey); err != nil {
+			fmt.Printf("[warn] Invalid server_key configuration: %v\n", err)
+		} else {
+			config.ServerKey = strings.ToLower(serverKey)
+		}
+	}
+
 	privateKeyHexMatch := rePrivateKey.FindSubmatch(data)
 	if privateKeyHexMatch != nil && len(privateKeyHexMatch) == 2 {
 		pkHex := string(privateKeyHexMatch[1])
@@ -129,6 +148,33 @@ func parseConfig(data []byte, name string) Config {
 	}
 	config.Follows = s83.ParseSpringfileFollows(data)
 
+	config.client = &http.Client{}
+	reCA := regexp.MustCompile(`(?m)^ca\s*=\s*(.*)$`)
+	caMatch := reCA.FindSubmatch(data)
+	if caMatch != nil && len(caMatch) == 2 && len(caMatch[1]) > 0 {
+		caPath := string(caMatch[1])
+		client, err := clientTrusting(caPath)
+		if err != nil {
+			fmt.Printf("[warn] Invalid ca configuration: %v\n", err)
+		} else {
+			config.CA = caPath
+			config.client = client
+		}
+	}
+
+	// servers with private reads require GETs signed by an allowed key
+	reSignReads := regexp.MustCompile(`(?m)^sign_reads\s*=\s*true\s*$`)
+	if reSignReads.Match(data) {
+		if config.Creator.PrivateKey == nil {
+			fmt.Println("[warn] Invalid sign_reads configuration: requires a secret")
+		} else if config.Server == nil {
+			fmt.Println("[warn] Invalid sign_reads configuration: requires a server")
+		} else {
+			config.SignReads = true
+			config.client = signingClient(config.client, config.Creator, config.Server)
+		}
+	}
+
 	// load templates
 	config.templates = template.Must(template.ParseFS(resources, "templates/*.tmpl"))
 	if config.templates == nil {
@@ -146,6 +192,60 @@ func parseConfig(data []byte, name string) Config {
 	return config
 }
 
+// clientTrusting returns an http.Client that trusts the system roots and the
+// PEM encoded certificate(s) at caPath
+func clientTrusting(caPath string) (*http.Client, error) {
+	pem, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caPath)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
+
+// signingTransport signs body-less requests to a server with a creator's key.
+// Requests to anywhere else (e.g. followed boards on other servers) are sent
+// unsigned, so no other host gets a signature it could replay.
+type signingTransport struct {
+	creator s83.Creator
+	server  *url.URL
+	next    http.RoundTripper
+}
+
+func (t signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if (req.Body == nil || req.Body == http.NoBody) && t.signs(req.URL) {
+		// RoundTrippers must not modify the original request
+		req = req.Clone(req.Context())
+		t.creator.SignRequest(req, []byte{})
+	}
+	return t.next.RoundTrip(req)
+}
+
+func (t signingTransport) signs(u *url.URL) bool {
+	return t.server != nil && u.Scheme == t.server.Scheme && strings.EqualFold(u.Host, t.server.Host)
+}
+
+// signingClient wraps a client so its GETs to server are signed by creator
+func signingClient(client *http.Client, creator s83.Creator, server *url.URL) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	signed := *client
+	signed.Transport = signingTransport{creator, server, next}
+	return &signed
+}
+
 func loadConfig(name string) Config {
 	configPath := configPath(name)
 
@@ -180,7 +280,16 @@ func (config Config) String() string {
 	display := fmt.Sprintf("name    : %s\n", config.Name)
 	display += fmt.Sprintf("path    : %s\n", config.Path())
 	display += fmt.Sprintf("server  : %s\n", config.Server)
+	if config.ServerKey != "" {
+		display += fmt.Sprintf("srv key : %s\n", config.ServerKey)
+	}
+	if config.CA != "" {
+		display += fmt.Sprintf("ca      : %s\n", config.CA)
+	}
 	display += fmt.Sprintf("pub     : %s\n", config.Creator)
+	if config.SignReads {
+		display += fmt.Sprintf("reads   : signed\n")
+	}
 	display += fmt.Sprintf("---------\nfollows :\n")
 	for _, follow := range config.Follows {
 		display += fmt.Sprintf("%s\n", follow)
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/royragsdale/s83"
 )
 
 func TestLoad(t *testing.T) {
@@ -16,3 +23,103 @@ func TestLoad(t *testing.T) {
 
 	loadConfig(defaultConfigName)
 }
+
+func TestServerKey(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	for _, name := range []string{"pinned", "invalid"} {
+		if err := os.MkdirAll(dataPath(name), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := parseConfig([]byte("server = https://example.com\nserver_key = "+strings.ToUpper(s83.TestPublic)), "pinned")
+	if config.ServerKey != s83.TestPublic {
+		t.Errorf("server key should be pinned: %q", config.ServerKey)
+	}
+
+	config = parseConfig([]byte("server = https://example.com\nserver_key = nope"), "invalid")
+	if config.ServerKey != "" {
+		t.Errorf("invalid server key should be ignored: %q", config.ServerKey)
+	}
+}
+
+func TestCA(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	for _, name := range []string{"trusted", "untrusted"} {
+		if err := os.MkdirAll(dataPath(name), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caPath, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := parseConfig([]byte("server = "+srv.URL+"\nca = "+caPath), "trusted")
+	if config.CA != caPath {
+		t.Errorf("ca should be loaded: %q", config.CA)
+	}
+	res, err := config.client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("client should trust the ca: %v", err)
+	}
+	res.Body.Close()
+
+	// without the ca the server's certificate is unknown
+	config = parseConfig([]byte("server = "+srv.URL), "untrusted")
+	if res, err := config.client.Get(srv.URL); err == nil {
+		res.Body.Close()
+		t.Error("client should not trust the test server")
+	}
+
+	notPEM := filepath.Join(t.TempDir(), "ca.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config = parseConfig([]byte("ca = "+notPEM), "untrusted")
+	if config.CA != "" {
+		t.Errorf("invalid ca should be ignored: %q", config.CA)
+	}
+	if _, err := clientTrusting(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("missing ca should fail")
+	}
+}
+
+func TestSignReads(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if err := os.MkdirAll(dataPath("signed"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	signed := map[string]bool{}
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			signed[name] = req.Header.Get(s83.AuthSignatureHeader) != ""
+		})
+	}
+	server := httptest.NewServer(handler("server"))
+	defer server.Close()
+	other := httptest.NewServer(handler("other"))
+	defer other.Close()
+
+	config := parseConfig([]byte("server = "+server.URL+"\nsecret = "+s83.TestPrivate+"\nsign_reads = true"), "signed")
+	if !config.SignReads {
+		t.Fatal("reads should be signed")
+	}
+	for _, u := range []string{server.URL + "/feed", other.URL + "/feed"} {
+		res, err := config.client.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	// only the profile's server gets signed reads
+	if !signed["server"] || signed["other"] {
+		t.Errorf("signed reads: %v", signed)
+	}
+}
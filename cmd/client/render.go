@@ -42,12 +42,9 @@ func nonce() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-// TODO: clean up follower/single board edge case
-func (config Config) renderBoards(newBoards map[string]s83.Board, localBoards map[string]s83.Board, follows []s83.Follow, outF *os.File) {
-
-	boards := []s83.Board{}
-
-	// simple Follow based ordering (merge new and local boards)
+// mergeBoards uses simple Follow based ordering (merge new and local boards)
+func mergeBoards(newBoards map[string]s83.Board, localBoards map[string]s83.Board, follows []s83.Follow) []s83.FeedItem {
+	items := []s83.FeedItem{}
 	for _, f := range follows {
 		key := f.Key()
 		var b s83.Board
@@ -59,7 +56,17 @@ func (config Config) renderBoards(newBoards map[string]s83.Board, localBoards ma
 				continue
 			}
 		}
-		boards = append(boards, b)
+		items = append(items, s83.FeedItem{Board: b, URL: f.URL()})
+	}
+	return items
+}
+
+// TODO: clean up follower/single board edge case
+func (config Config) renderBoards(newBoards map[string]s83.Board, localBoards map[string]s83.Board, follows []s83.Follow, outF *os.File) {
+
+	boards := []s83.Board{}
+	for _, item := range mergeBoards(newBoards, localBoards, follows) {
+		boards = append(boards, item.Board)
 	}
 
 	nonce, err := nonce()
@@ -96,3 +103,27 @@ func (c Config) outPath() string {
 	fName := time.Now().Format("daily-spring-2006-01-02T15:04:05.html")
 	return filepath.Join(c.DataPath(), fName)
 }
+
+// fileURL is a file:// URL for a local path
+func fileURL(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return "file://" + filepath.ToSlash(path)
+}
+
+// writeAtom writes the aggregated follows as an Atom feed (e.g. for a feed
+// reader) alongside the 'Daily Spring' at htmlPath
+func (config Config) writeAtom(newBoards map[string]s83.Board, localBoards map[string]s83.Board, follows []s83.Follow, htmlPath string, atomPath string) error {
+	feed := s83.Feed{
+		Title:   fmt.Sprintf("Daily Spring (%s)", config.Name),
+		Link:    fileURL(htmlPath),
+		FeedURL: fileURL(atomPath),
+		Items:   mergeBoards(newBoards, localBoards, follows),
+	}
+	data, err := feed.Atom()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(atomPath, data, 0644)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/royragsdale/s83"
+)
+
+// admin requests must be signed within this window of the server's clock
+const adminMaxSkew = 5 * time.Minute
+
+// admin requests are small, there is no reason to accept anything large
+const adminMaxBody = 4096
+
+const adminPrefix = "/admin/"
+
+// seenSignatures tracks recently used request signatures (admin requests and
+// signed reads) to prevent replays inside of the allowed clock skew window.
+type seenSignatures struct {
+	mu        sync.Mutex
+	maxSkew   time.Duration
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newSeenSignatures(maxSkew time.Duration) *seenSignatures {
+	return &seenSignatures{maxSkew: maxSkew, seen: map[string]time.Time{}, lastPrune: time.Now()}
+}
+
+// add returns false if the signature has already been seen.
+func (s *seenSignatures) add(sig s83.Signature) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// forget anything that would now fail the timestamp check anyway
+	now := time.Now()
+	if now.Sub(s.lastPrune) > pruneInterval {
+		for k, expires := range s.seen {
+			if now.After(expires) {
+				delete(s.seen, k)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	if expires, ok := s.seen[sig.String()]; ok && !now.After(expires) {
+		return false
+	}
+	s.seen[sig.String()] = now.Add(2 * s.maxSkew)
+	return true
+}
+
+type adminBoard struct {
+	Key       string `json:"key"`
+	Timestamp string `json:"timestamp"`
+	Expires   string `json:"expires"`
+	Signature string `json:"signature"`
+	Size      int    `json:"size"`
+}
+
+type adminStats struct {
+	Boards  int    `json:"boards"`
+	Blocked int    `json:"blocked"`
+	TTL     int    `json:"ttl"`
+	Uptime  string `json:"uptime"`
+}
+
+type adminResult struct {
+	Result string `json:"result"`
+}
+
+// handleAdmin authenticates and routes requests to the admin API.
+//
+//	GET    /admin/boards          list boards
+//	DELETE /admin/boards/<key>    remove a board
+//	GET    /admin/blocked         list blocked keys
+//	PUT    /admin/blocked/<key>   block a key
+//	DELETE /admin/blocked/<key>   unblock a key
+//	GET    /admin/allowed         list keys allowed to publish
+//	PUT    /admin/allowed/<key>   allow a key to publish
+//	DELETE /admin/allowed/<key>   disallow a key
+//	GET    /admin/stats           server statistics
+//	POST   /admin/sweep           remove expired boards
+func (srv *Server) handleAdmin(w http.ResponseWriter, req *http.Request) error {
+	if srv.admin == nil {
+		return newHTTPError(http.StatusNotFound, "admin API not configured")
+	}
+
+	if err := srv.authenticateAdmin(req); err != nil {
+		return err
+	}
+
+	resource, key := adminRoute(req.URL.Path)
+	switch {
+	case resource == "boards" && key == "" && req.Method == http.MethodGet:
+		return srv.adminListBoards(w)
+	case resource == "boards" && key != "" && req.Method == http.MethodDelete:
+		return srv.adminRemoveBoard(w, key)
+	case resource == "blocked" && key == "" && req.Method == http.MethodGet:
+		return writeJSON(w, srv.store.BlockList())
+	case resource == "blocked" && key != "" && req.Method == http.MethodPut:
+		return srv.adminBlock(w, key)
+	case resource == "blocked" && key != "" && req.Method == http.MethodDelete:
+		return srv.adminUnblock(w, key)
+	case resource == "allowed" && key == "" && req.Method == http.MethodGet:
+		return writeJSON(w, srv.store.AllowList())
+	case resource == "allowed" && key != "" && req.Method == http.MethodPut:
+		return srv.adminAllow(w, key)
+	case resource == "allowed" && key != "" && req.Method == http.MethodDelete:
+		return srv.adminDisallow(w, key)
+	case resource == "stats" && key == "" && req.Method == http.MethodGet:
+		return writeJSON(w, srv.adminStats())
+	case resource == "sweep" && key == "" && req.Method == http.MethodPost:
+		n := srv.sweep()
+		return writeJSON(w, adminResult{fmt.Sprintf("removed %d expired boards", n)})
+	}
+
+	return newHTTPError(http.StatusNotFound, "unknown admin endpoint")
+}
+
+// authenticateAdmin ensures a request was signed by the admin key
+func (srv *Server) authenticateAdmin(req *http.Request) error {
+	body := []byte{}
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(req.Body, adminMaxBody+1))
+		if err != nil {
+			return newHTTPErrorLog(http.StatusBadRequest, "unreadable body", err)
+		}
+	}
+	if len(body) > adminMaxBody {
+		return newHTTPError(http.StatusRequestEntityTooLarge, "admin request too large")
+	}
+
+	pub, sig, err := s83.VerifyRequest(req, body, adminMaxSkew)
+	if err != nil {
+		return newHTTPErrorLog(http.StatusUnauthorized, err.Error(), fmt.Errorf("admin auth failed: %s %s", req.Method, req.URL.Path))
+	}
+	if pub.String() != srv.admin.String() {
+		return newHTTPErrorLog(http.StatusForbidden, "not the admin key", fmt.Errorf("admin auth from non-admin key: %s", pub))
+	}
+	if !srv.adminSeen.add(sig) {
+		return newHTTPError(http.StatusUnauthorized, "replayed request")
+	}
+
+	reqLog(req).Info("admin request", "method", req.Method, "path", req.URL.Path)
+	return nil
+}
+
+// adminRoute splits an admin path into the resource and an optional key
+func adminRoute(path string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(path, adminPrefix), "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+var reAdminKey = regexp.MustCompile(`^[0-9A-Fa-f]{64}$`)
+
+// adminKey validates a key and lower cases it, as boards are stored and served
+func adminKey(key string) (string, error) {
+	if !reAdminKey.MatchString(key) {
+		return "", newHTTPError(http.StatusBadRequest, "invalid key")
+	}
+	return strings.ToLower(key), nil
+}
+
+func (srv *Server) adminListBoards(w http.ResponseWriter) error {
+	boards := []adminBoard{}
+	for _, b := range srv.store.Boards() {
+		boards = append(boards, adminBoard{
+			b.Key(),
+			b.Timestamp(),
+			srv.boardExpiry(b).Format(http.TimeFormat),
+			b.Signature(),
+			len(b.Content),
+		})
+	}
+	return writeJSON(w, boards)
+}
+
+func (srv *Server) adminRemoveBoard(w http.ResponseWriter, key string) error {
+	key, err := adminKey(key)
+	if err != nil {
+		return err
+	}
+	if err := srv.store.Remove(key); err != nil {
+		return newHTTPErrorLog(http.StatusNotFound, "board not found", err)
+	}
+	logger.Info("admin removed board", "key", key)
+	return writeJSON(w, adminResult{"removed " + key})
+}
+
+func (srv *Server) adminBlock(w http.ResponseWriter, key string) error {
+	key, err := adminKey(key)
+	if err != nil {
+		return err
+	}
+	if err := srv.store.Block(key); err != nil {
+		return newHTTPErrorLog(http.StatusInternalServerError, "failed saving block list", err)
+	}
+	logger.Info("admin blocked key", "key", key)
+	return writeJSON(w, adminResult{"blocked " + key})
+}
+
+func (srv *Server) adminUnblock(w http.ResponseWriter, key string) error {
+	key, err := adminKey(key)
+	if err != nil {
+		return err
+	}
+	if !srv.store.Blocked(key) {
+		return newHTTPError(http.StatusNotFound, "key not blocked")
+	}
+	if err := srv.store.Unblock(key); err != nil {
+		return newHTTPErrorLog(http.StatusInternalServerError, "failed saving block list", err)
+	}
+	logger.Info("admin unblocked key", "key", key)
+	return writeJSON(w, adminResult{"unblocked " + key})
+}
+
+func (srv *Server) adminAllow(w http.ResponseWriter, key string) error {
+	key, err := adminKey(key)
+	if err != nil {
+		return err
+	}
+	if err := srv.store.Allow(key); err != nil {
+		return newHTTPErrorLog(http.StatusInternalServerError, "failed saving allow list", err)
+	}
+	logger.Info("admin allowed key", "key", key)
+	return writeJSON(w, adminResult{"allowed " + key})
+}
+
+func (srv *Server) adminDisallow(w http.ResponseWriter, key string) error {
+	key, err := adminKey(key)
+	if err != nil {
+		return err
+	}
+	if !srv.store.Allowed(key) {
+		return newHTTPError(http.StatusNotFound, "key not allowed")
+	}
+	if err := srv.store.Disallow(key); err != nil {
+		return newHTTPErrorLog(http.StatusInternalServerError, "failed saving allow list", err)
+	}
+	logger.Info("admin disallowed key", "key", key)
+	return writeJSON(w, adminResult{"disallowed " + key})
+}
+
+func (srv *Server) adminStats() adminStats {
+	return adminStats{
+		srv.store.Count(),
+		len(srv.store.BlockList()),
+		srv.ttl,
+		time.Since(srv.started).Round(time.Second).String(),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
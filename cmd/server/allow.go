@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/royragsdale/s83"
+	"github.com/royragsdale/s83/store"
+)
+
+// Publishing modes (PUBLISH)
+const (
+	publishOpen      = "open"      // any valid key can publish
+	publishAllowlist = "allowlist" // only allowed keys can publish
+)
+
+// the admin board lists allowed keys in a data-spring-allow attribute, e.g.
+// <div data-spring-allow="<key> <key>"></div>
+const allowAttr = "allow"
+
+// signed reads (PRIVATE_READ) must be made within this window of the server's
+// clock
+const readMaxSkew = 5 * time.Minute
+
+// loadAllowFile reads keys (one per line, # comments) allowed to publish
+func loadAllowFile(path string) (map[string]bool, error) {
+	keys := map[string]bool{}
+	if path == "" {
+		return keys, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if !reAdminKey.MatchString(line) {
+			return nil, fmt.Errorf("%s:%d: invalid key: %s", path, n+1, line)
+		}
+		keys[strings.ToLower(line)] = true
+	}
+	return keys, nil
+}
+
+// allowed reports whether a key may publish (and read when reads are
+// private). Keys are allowed by the admin board, the allow file, or the
+// store's allow list (e.g. after redeeming an invite).
+func (srv *Server) allowed(key string) bool {
+	key = strings.ToLower(key)
+	if srv.allowFile[key] || srv.store.Allowed(key) {
+		return true
+	}
+	if srv.admin == nil {
+		return false
+	}
+	if key == srv.admin.String() {
+		return true
+	}
+	return srv.adminBoardAllows(key)
+}
+
+// adminBoardAllows checks the keys listed by the (admin signed) admin board
+func (srv *Server) adminBoardAllows(key string) bool {
+	board, err := srv.store.Get(srv.admin.String())
+	if err != nil {
+		return false
+	}
+	for _, allowed := range strings.Fields(s83.ParseSpringData(board.Content)[allowAttr]) {
+		if strings.ToLower(strings.Trim(allowed, ",")) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// admitPublisher enforces the allowlist mode on a PUT (after the board's
+// signature has been verified). Publishers that are not allowed can present
+// an invite token once to register their key.
+func (srv *Server) admitPublisher(req *http.Request, key string) error {
+	if srv.publish != publishAllowlist || srv.allowed(key) {
+		return nil
+	}
+
+	token := req.Header.Get(s83.InviteHeader)
+	if token == "" || srv.admin == nil {
+		return newHTTPErrorLog(http.StatusForbidden, "publishing is invite only", fmt.Errorf("PUT from key not on allowlist: %s", key))
+	}
+
+	invite, err := s83.ParseInvite(token, *srv.admin)
+	if err != nil {
+		return newHTTPErrorLog(http.StatusForbidden, "invalid invite", fmt.Errorf("invalid invite from key: %s : %w", key, err))
+	}
+	err = srv.store.Redeem(invite.ID, key)
+	if errors.Is(err, store.ErrInviteUsed) {
+		return newHTTPErrorLog(http.StatusForbidden, "invite already used", fmt.Errorf("reused invite %s from key: %s", invite.ID, key))
+	} else if err != nil {
+		return newHTTPErrorLog(http.StatusInternalServerError, "", fmt.Errorf("failed redeeming invite: %w", err))
+	}
+
+	reqLog(req).Info("registered publisher with invite", "key", key, "invite", invite.ID)
+	return nil
+}
+
+// authorizeRead requires reads to be signed by an allowed key when reads are
+// private
+func (srv *Server) authorizeRead(req *http.Request) error {
+	if !srv.privateRead {
+		return nil
+	}
+	pub, sig, err := s83.VerifyRequest(req, []byte{}, readMaxSkew)
+	if err != nil {
+		return newHTTPError(http.StatusUnauthorized, "reads are private: "+err.Error())
+	}
+	if !srv.allowed(pub.String()) {
+		return newHTTPErrorLog(http.StatusForbidden, "reads are private", fmt.Errorf("read from key not on allowlist: %s", pub))
+	}
+	if !srv.readSeen.add(sig) {
+		return newHTTPError(http.StatusUnauthorized, "replayed request")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/royragsdale/s83"
+)
+
+const (
+	changesDefaultLimit = 100
+	changesMaxLimit     = 1000
+)
+
+// handleChanges lists boards changed (or removed) after a cursor
+// (?since=<seq>), in the order they were changed (?limit=<n> per page)
+func (srv *Server) handleChanges(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet {
+		return newHTTPError(http.StatusMethodNotAllowed, "use GET")
+	}
+
+	since := uint64(0)
+	if s := req.URL.Query().Get("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return newHTTPError(http.StatusBadRequest, "invalid since")
+		}
+	}
+	limit := changesDefaultLimit
+	if l := req.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			return newHTTPError(http.StatusBadRequest, "invalid limit")
+		}
+		if limit > changesMaxLimit {
+			limit = changesMaxLimit
+		}
+	}
+
+	// one extra to know if there are more
+	entries := srv.store.Changes(since, limit+1)
+	page := s83.ChangesPage{Changes: []s83.Change{}, Next: since}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		page.More = true
+	}
+	for _, e := range entries {
+		// skipped, but still moves the cursor along
+		page.Next = e.Seq
+		if srv.blocked(e.Key) {
+			continue
+		}
+		if e.Removed {
+			page.Changes = append(page.Changes, s83.Change{Seq: e.Seq, Key: e.Key, Removed: true})
+			continue
+		}
+		if srv.boardExpired(e.Board) {
+			continue
+		}
+		page.Changes = append(page.Changes, s83.Change{
+			Seq:       e.Seq,
+			Key:       e.Board.Key(),
+			Timestamp: e.Board.Time().UTC().Format(s83.TimeFormat8601),
+			Signature: e.Board.Signature(),
+		})
+	}
+	return writeJSON(w, page)
+}
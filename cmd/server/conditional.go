@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/royragsdale/s83"
+)
+
+// Conditional requests (RFC 7232) on boards. A board's signature changes with
+// any change to its content so it makes a natural strong validator.
+
+// boardETag is the entity tag for the raw signed board
+func boardETag(board s83.Board) string {
+	return `"` + board.Signature() + `"`
+}
+
+// wrappedETag is the entity tag for the page wrapping a board for browsers
+func wrappedETag(board s83.Board) string {
+	return `"` + board.Signature() + `-html"`
+}
+
+// setValidators adds the Last-Modified and ETag headers for a board
+func setValidators(w http.ResponseWriter, board s83.Board, etag string) {
+	w.Header().Set("Last-Modified", board.Timestamp())
+	w.Header().Set("ETag", etag)
+}
+
+// etagMatch reports whether an If-Match/If-None-Match header (a list of
+// entity tags, or *) matches the current entity tag, using weak comparison.
+// An empty etag means there is no current board.
+func etagMatch(header string, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditions evaluates the conditional headers of a request against the
+// current board (nil if there is none) in the order given by RFC 7232 (6).
+// It returns 0 if the request should proceed, or the status to respond with
+// (304 Not Modified or 412 Precondition Failed).
+func preconditions(req *http.Request, current *s83.Board, etag string) int {
+	if current == nil {
+		etag = ""
+	}
+	safe := req.Method == http.MethodGet || req.Method == http.MethodHead
+
+	// 1/2. the board must (still) match
+	if im := req.Header.Get("If-Match"); im != "" {
+		if !etagMatch(im, etag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius, err := mail.ParseDate(req.Header.Get("If-Unmodified-Since")); err == nil {
+		if current != nil && current.After(ius) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	// 3/4. the board must have changed
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		if etagMatch(inm, etag) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims, err := mail.ParseDate(req.Header.Get("If-Modified-Since")); err == nil && safe {
+		if current != nil && !current.After(ims) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
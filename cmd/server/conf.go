@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// conf resolves settings from (lowest to highest precedence) defaults, an
+// optional config file, environment variables and command line flags.
+//
+// The config file uses the same `key = value` lines as the client profile.
+// Keys are the variable names in any case. A `[section]` line prefixes the
+// keys that follow it, so these are equivalent:
+//
+//	tls_cert = cert.pem
+//
+//	[tls]
+//	cert = cert.pem
+//
+// A `[realm <host>]` section configures a realm (see realm.go) instead.
+type conf struct {
+	path   string            // config file (if any)
+	file   map[string]string // from the config file
+	realms []realmConf       // from the config file
+	flags  map[string]string // explicitly set on the command line
+	errs   []string
+	failed map[string]bool // settings with an error recorded
+}
+
+// realmConf holds the settings from a `[realm <host>]` section
+type realmConf struct {
+	host   string
+	values map[string]string
+}
+
+// envConf only consults environment variables (and defaults)
+func envConf() *conf {
+	return &conf{file: map[string]string{}, flags: map[string]string{}}
+}
+
+// loadConf reads a config file (if path is not empty) and records any
+// settings given as flags.
+func loadConf(path string, flags map[string]string) (*conf, error) {
+	c := envConf()
+	c.flags = flags
+	if path == "" {
+		return c, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c.path = path
+	c.file, c.realms, err = parseConfFile(f, path)
+	return c, err
+}
+
+// parseConfFile parses `key = value` lines, with optional `[section]` prefixes
+// and `[realm <host>]` sections
+func parseConfFile(r io.Reader, path string) (map[string]string, []realmConf, error) {
+	values := map[string]string{}
+	realms := []realmConf{}
+	var realm *realmConf
+	prefix := ""
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		// skip blank/comment lines
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section := strings.TrimSpace(line[1 : len(line)-1])
+			prefix = ""
+			realm = nil
+			if kind, host, ok := strings.Cut(section, " "); ok && strings.EqualFold(kind, "realm") {
+				host = realmHost(host)
+				for _, r := range realms {
+					if r.host == host {
+						return nil, nil, fmt.Errorf("%s:%d: duplicate realm: %s", path, n, host)
+					}
+				}
+				realms = append(realms, realmConf{host, map[string]string{}})
+				realm = &realms[len(realms)-1]
+			} else if section != "" {
+				prefix = confName(section) + "_"
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("%s:%d: expected `key = value`: %s", path, n, line)
+		}
+		name := prefix + confName(strings.TrimSpace(key))
+		if realm != nil {
+			if !isRealmVar(name) {
+				return nil, nil, fmt.Errorf("%s:%d: not a realm setting: %s (use one of %s)", path, n, name, strings.Join(realmVars, ", "))
+			}
+			realm.values[name] = unquote(strings.TrimSpace(value))
+			continue
+		}
+		if _, known := defaultVars[name]; !known {
+			return nil, nil, fmt.Errorf("%s:%d: unknown setting: %s", path, n, name)
+		}
+		values[name] = unquote(strings.TrimSpace(value))
+	}
+	return values, realms, scanner.Err()
+}
+
+// confName normalizes a key from a file or flag to the variable name
+func confName(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// flagName is the command line flag for a variable (e.g. RATE_IP -> rate-ip)
+func flagName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		if s, err := strconv.Unquote(value); err == nil {
+			return s
+		}
+	}
+	return value
+}
+
+// registerConfFlags adds a flag for every variable to fs. The returned
+// function collects the flags that were explicitly set (after parsing).
+func registerConfFlags(fs *flag.FlagSet) func() map[string]string {
+	for _, name := range envVars {
+		fs.String(flagName(name), defaultVars[name], varHelp[name])
+	}
+	return func() map[string]string {
+		set := map[string]string{}
+		fs.Visit(func(f *flag.Flag) {
+			name := confName(f.Name)
+			if _, known := defaultVars[name]; known {
+				set[name] = f.Value.String()
+			}
+		})
+		return set
+	}
+}
+
+// lookup returns the effective value of a variable and where it came from
+func (c *conf) lookup(name string) (string, string) {
+	if val, ok := c.flags[name]; ok {
+		return val, "flag -" + flagName(name)
+	}
+	if val := os.Getenv(name); val != "" {
+		return val, "environment"
+	}
+	if val, ok := c.file[name]; ok {
+		return val, "config file " + c.path
+	}
+
+	// get default
+	val, ok := defaultVars[name]
+	if !ok {
+		panic("attempted to get variable with no default: " + name)
+	}
+	return val, "default"
+}
+
+// invalid records a validation error naming the setting and its source
+func (c *conf) invalid(name string, format string, args ...interface{}) {
+	val, source := c.lookup(name)
+	msg := fmt.Sprintf(format, args...)
+	c.errs = append(c.errs, fmt.Sprintf("%s=%q (from %s): %s", name, val, source, msg))
+	if c.failed == nil {
+		c.failed = map[string]bool{}
+	}
+	c.failed[name] = true
+}
+
+// ok reports whether no error has been recorded for a setting, so range checks
+// are skipped for values that failed to parse
+func (c *conf) ok(name string) bool {
+	return !c.failed[name]
+}
+
+// err reports every validation error found so far
+func (c *conf) err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  " + strings.Join(c.errs, "\n  "))
+}
+
+func (c *conf) str(name string) string {
+	val, _ := c.lookup(name)
+	return val
+}
+
+func (c *conf) int(name string) int {
+	n, err := strconv.Atoi(c.str(name))
+	if err != nil {
+		c.invalid(name, "not an integer")
+	}
+	return n
+}
+
+func (c *conf) float(name string) float64 {
+	f, err := strconv.ParseFloat(c.str(name), 64)
+	if err != nil {
+		c.invalid(name, "not a number")
+	}
+	return f
+}
+
+func (c *conf) bool(name string) bool {
+	b, err := strconv.ParseBool(c.str(name))
+	if err != nil {
+		c.invalid(name, "not a boolean (use true or false)")
+	}
+	return b
+}
+
+func (c *conf) duration(name string) time.Duration {
+	d, err := time.ParseDuration(c.str(name))
+	if err != nil {
+		c.invalid(name, "not a duration (e.g. 10s, 2m)")
+	} else if d < 0 {
+		c.invalid(name, "must not be negative")
+	}
+	return d
+}
+
+// print writes the effective configuration in config file format (which can
+// be read back with -config), noting where each value came from
+func (c *conf) print(w io.Writer) {
+	var buf bytes.Buffer
+	for _, name := range envVars {
+		val, source := c.lookup(name)
+		fmt.Fprintf(&buf, "# %s\n%s = %s\n", source, strings.ToLower(name), quoteValue(val))
+	}
+	for _, r := range c.realms {
+		fmt.Fprintf(&buf, "\n[realm %s]\n", r.host)
+		for _, name := range realmVars {
+			val, source := c.realmLookup(r, name)
+			fmt.Fprintf(&buf, "# %s\n%s = %s\n", source, strings.ToLower(name), quoteValue(val))
+		}
+	}
+	w.Write(buf.Bytes())
+}
+
+func quoteValue(val string) string {
+	if val == "" || strings.ContainsAny(val, " #\"") {
+		return strconv.Quote(val)
+	}
+	return val
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/royragsdale/s83"
+)
+
+// boards opt out of the directory with a data-spring-unlisted attribute
+const unlistedAttr = "unlisted"
+
+// directoryPage is one page of recently updated boards for the homepage
+type directoryPage struct {
+	Boards   []s83.Board
+	Page     int
+	PrevPage int // 0 if there is no previous page
+	NextPage int // 0 if there is no next page
+}
+
+// listed reports whether a board should appear in the public directory
+func (srv *Server) listed(board s83.Board) bool {
+	if srv.blocked(board.Key()) || srv.boardExpired(board) {
+		return false
+	}
+	val, unlisted := s83.ParseSpringData(board.Content)[unlistedAttr]
+	return !unlisted || val == "false"
+}
+
+// recentBoards returns every listed board, most recently updated first
+func (srv *Server) recentBoards() []s83.Board {
+	boards := []s83.Board{}
+	for _, b := range srv.store.Boards() {
+		if srv.listed(b) {
+			boards = append(boards, b)
+		}
+	}
+	sort.SliceStable(boards, func(i, j int) bool { return boards[i].AfterBoard(boards[j]) })
+	return boards
+}
+
+// directory returns a (1-based) page of the directory
+func (srv *Server) directory(page int) directoryPage {
+	boards := srv.recentBoards()
+	size := srv.directoryPageSize
+
+	pages := (len(boards) + size - 1) / size
+	if page < 1 {
+		page = 1
+	} else if pages > 0 && page > pages {
+		page = pages
+	}
+
+	start := (page - 1) * size
+	end := start + size
+	if end > len(boards) {
+		end = len(boards)
+	}
+
+	dp := directoryPage{Boards: boards[start:end], Page: page}
+	if page > 1 {
+		dp.PrevPage = page - 1
+	}
+	if page < pages {
+		dp.NextPage = page + 1
+	}
+	return dp
+}
+
+// pageParam parses the ?page= query parameter (defaulting to the first page)
+func pageParam(req *http.Request) int {
+	page, err := strconv.Atoi(req.URL.Query().Get("page"))
+	if err != nil {
+		return 1
+	}
+	return page
+}
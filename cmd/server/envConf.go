@@ -1,11 +1,14 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
-	"os"
-	"strconv"
+	"net"
+	"path/filepath"
+	"regexp"
+	"time"
 
 	"github.com/royragsdale/s83"
 	"github.com/royragsdale/s83/store"
@@ -17,132 +20,518 @@ const envStore = "STORE"
 const envTTL = "TTL"
 const envTitle = "TITLE"
 const envAdmin = "ADMIN_BOARD"
+const envServerKey = "SERVER_KEY"
+const envPeers = "PEERS"
+const envPublish = "PUBLISH"
+const envAllowlistFile = "ALLOWLIST_FILE"
+const envPrivateRead = "PRIVATE_READ"
+const envValidateKeys = "VALIDATE_KEYS"
+const envMaxFutureSkew = "MAX_FUTURE_SKEW"
+const envDenyPatterns = "DENY_PATTERNS"
+const envMaxDailyUpdates = "MAX_DAILY_UPDATES"
+const envHookCommand = "HOOK_COMMAND"
+const envHookURL = "HOOK_URL"
+const envHookWorkers = "HOOK_WORKERS"
+const envHookQueue = "HOOK_QUEUE"
+const envHookTimeout = "HOOK_TIMEOUT"
+const envUpstream = "UPSTREAM"
+const envMirrorMaxAge = "MIRROR_MAX_AGE"
+const envMirrorFillRate = "MIRROR_FILL_RATE"
+const envMirrorFillBurst = "MIRROR_FILL_BURST"
+const envRateIP = "RATE_IP"
+const envRateIPBurst = "RATE_IP_BURST"
+const envRateKey = "RATE_KEY"
+const envRateKeyBurst = "RATE_KEY_BURST"
+const envRateAllow = "RATE_ALLOW"
+const envReadTimeout = "READ_TIMEOUT"
+const envHeaderTimeout = "READ_HEADER_TIMEOUT"
+const envWriteTimeout = "WRITE_TIMEOUT"
+const envIdleTimeout = "IDLE_TIMEOUT"
+const envShutdownTimeout = "SHUTDOWN_TIMEOUT"
+const envMaxHeaderBytes = "MAX_HEADER_BYTES"
+const envMetrics = "METRICS"
+const envLogLevel = "LOG_LEVEL"
+const envLogFormat = "LOG_FORMAT"
+const envTrustedProxies = "TRUSTED_PROXIES"
+const envAccessLog = "ACCESS_LOG"
+const envTLSCert = "TLS_CERT"
+const envTLSKey = "TLS_KEY"
+const envRedirectPort = "REDIRECT_PORT"
+const envDirectory = "DIRECTORY"
+const envDirectoryPageSize = "DIRECTORY_PAGE_SIZE"
 
-var envVars = []string{envHost, envPort, envStore, envTTL, envTitle, envAdmin}
+var envVars = []string{envHost, envPort, envStore, envTTL, envTitle, envAdmin, envServerKey, envPeers,
+	envPublish, envAllowlistFile, envPrivateRead,
+	envValidateKeys, envMaxFutureSkew, envDenyPatterns, envMaxDailyUpdates,
+	envHookCommand, envHookURL, envHookWorkers, envHookQueue, envHookTimeout,
+	envUpstream, envMirrorMaxAge, envMirrorFillRate, envMirrorFillBurst,
+	envRateIP, envRateIPBurst, envRateKey, envRateKeyBurst, envRateAllow,
+	envReadTimeout, envHeaderTimeout, envWriteTimeout, envIdleTimeout, envShutdownTimeout, envMaxHeaderBytes,
+	envMetrics, envLogLevel, envLogFormat, envTrustedProxies, envAccessLog,
+	envTLSCert, envTLSKey, envRedirectPort,
+	envDirectory, envDirectoryPageSize}
 
 var defaultVars = map[string]string{
-	envHost:  "",
-	envPort:  "8080",
-	envStore: "store",
-	envTTL:   "22",
-	envTitle: "s83d",
-	envAdmin: "",
+	envHost:      "",
+	envPort:      "8080",
+	envStore:     "store",
+	envTTL:       "22",
+	envTitle:     "s83d",
+	envAdmin:     "",
+	envServerKey: "",
+	envPeers:     "",
+
+	envPublish:       publishOpen,
+	envAllowlistFile: "",
+	envPrivateRead:   "false",
+
+	envValidateKeys:    "true",
+	envMaxFutureSkew:   "5m",
+	envDenyPatterns:    "",
+	envMaxDailyUpdates: "0",
+
+	envHookCommand: "",
+	envHookURL:     "",
+	envHookWorkers: "2",
+	envHookQueue:   "100",
+	envHookTimeout: "10s",
+
+	envUpstream:        "",
+	envMirrorMaxAge:    "5m",
+	envMirrorFillRate:  "5",
+	envMirrorFillBurst: "20",
+
+	envRateIP:       "10",
+	envRateIPBurst:  "40",
+	envRateKey:      "5",
+	envRateKeyBurst: "20",
+	envRateAllow:    "",
+
+	envReadTimeout:     "10s",
+	envHeaderTimeout:   "5s",
+	envWriteTimeout:    "10s",
+	envIdleTimeout:     "60s",
+	envShutdownTimeout: "15s",
+	envMaxHeaderBytes:  "8192",
+
+	envMetrics:        "true",
+	envLogLevel:       "info",
+	envLogFormat:      "logfmt",
+	envTrustedProxies: "",
+	envAccessLog:      "",
+	envTLSCert:        "",
+	envTLSKey:         "",
+	envRedirectPort:   "0",
+
+	envDirectory:         "false",
+	envDirectoryPageSize: "10",
+}
+
+var varHelp = map[string]string{
+	envHost:              "address to listen on",
+	envPort:              "port to listen on",
+	envStore:             "directory boards are stored in",
+	envTTL:               "days before a board expires (7-22)",
+	envTitle:             "title of the homepage",
+	envAdmin:             "admin board key (enables the admin API)",
+	envServerKey:         "path to the server identity key (default STORE/server.key)",
+	envPeers:             "peer server URLs advertised in the server info",
+	envPublish:           "who can publish: open or allowlist",
+	envAllowlistFile:     "file of keys allowed to publish (one per line)",
+	envPrivateRead:       "require signed reads by allowed keys",
+	envValidateKeys:      "reject boards from invalid or expired keys",
+	envMaxFutureSkew:     "how far in the future boards may be dated",
+	envDenyPatterns:      "file of regexps, boards matching any are rejected",
+	envMaxDailyUpdates:   "boards a key can publish per day (0 disables)",
+	envHookCommand:       "command run with each accepted board (JSON on stdin)",
+	envHookURL:           "URL each accepted board is POSTed to (JSON body)",
+	envHookWorkers:       "hooks run at once",
+	envHookQueue:         "accepted boards waiting for a hook before dropping",
+	envHookTimeout:       "max time for a hook to run",
+	envUpstream:          "server to mirror (read-only, PUTs are refused)",
+	envMirrorMaxAge:      "how long a mirrored board is served before rechecking",
+	envMirrorFillRate:    "upstream fetches per second (0 disables the limit)",
+	envMirrorFillBurst:   "burst of upstream fetches",
+	envRateIP:            "requests per second per address (0 disables)",
+	envRateIPBurst:       "burst of requests per address",
+	envRateKey:           "publishes per second per key (0 disables)",
+	envRateKeyBurst:      "burst of publishes per key",
+	envRateAllow:         "addresses/CIDRs exempt from rate limits",
+	envReadTimeout:       "max time to read a request",
+	envHeaderTimeout:     "max time to read request headers",
+	envWriteTimeout:      "max time to write a response",
+	envIdleTimeout:       "max time to keep idle connections",
+	envShutdownTimeout:   "max time to drain requests on shutdown",
+	envMaxHeaderBytes:    "max size of request headers",
+	envMetrics:           "serve /metrics",
+	envLogLevel:          "debug, info, warn or error",
+	envLogFormat:         "logfmt or json",
+	envTrustedProxies:    "proxies (addresses/CIDRs) trusted for X-Forwarded-For",
+	envAccessLog:         "path to a Common Log Format access log",
+	envTLSCert:           "path to a TLS certificate (enables HTTPS)",
+	envTLSKey:            "path to the TLS key",
+	envRedirectPort:      "port redirecting HTTP to HTTPS (0 disables)",
+	envDirectory:         "list recently updated boards on the homepage",
+	envDirectoryPageSize: "boards per directory page",
 }
 
 type Server struct {
 	host        string
 	port        int
 	store       *store.Store
+	lock        *store.DirLock // on the store while serving
 	ttl         int // days
 	title       string
 	admin       *s83.Publisher
+	identity    s83.Creator // signs the server info document
+	peers       []string
 	blockList   map[string]bool
 	testCreator s83.Creator // test key
 	templates   *template.Template
+	adminSeen   *seenSignatures
+	readSeen    *seenSignatures
+	writable    *writableCheck // readiness of the store
+	limits      *rateLimits
+	metrics     bool // serve /metrics
+	started     time.Time
+	events      *broker // streams board changes to subscribers
+	hooks       *hooks  // run for accepted boards (nil if none are configured)
+	mirror      *mirror // serves boards from an upstream server (nil if not a mirror)
+
+	// who can publish and read
+	publish     string          // open or allowlist
+	allowFile   map[string]bool // keys allowed by ALLOWLIST_FILE
+	privateRead bool            // reads must be signed by allowed keys
+
+	// PUT admission
+	validateKeys    bool
+	maxFutureSkew   time.Duration    // boards dated later are rejected
+	denyPatterns    []*regexp.Regexp // rejected board content
+	maxDailyUpdates int              // per key (0 disables)
+	admission       []PutPolicy      // run in order on every verified board
+
+	// other realms by host (this server is the default realm)
+	realms map[string]*Server
+
+	// public directory of boards on the homepage
+	directoryEnabled  bool
+	directoryPageSize int
+
+	// logging
+	trustedProxies []*net.IPNet // honor X-Forwarded-For from these
+	accessLog      *accessLog   // optional Common Log Format file
+
+	// TLS (disabled if no certificate is configured)
+	tlsCert      string
+	tlsKey       string
+	redirectPort int // plain HTTP port redirecting to HTTPS (0 disables)
+
+	// http.Server settings
+	readTimeout     time.Duration
+	headerTimeout   time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
+	maxHeaderBytes  int
 }
 
+// NewServerFromEnv configures a server from environment variables only
 func NewServerFromEnv() *Server {
+	return NewServer(envConf())
+}
 
-	// configurable from environment variables
-	host := varOrDefault(envHost)
-	port := intOrDefault(envPort)
-	ttl := intOrDefault(envTTL)
-	storePath := varOrDefault(envStore)
-	title := varOrDefault(envTitle)
-	adminKey := varOrDefault(envAdmin)
+// NewServer configures a server from the resolved settings. Any invalid
+// settings are reported together (naming each setting) before exiting.
+func NewServer(c *conf) *Server {
 
-	if ttl < 7 || ttl > 22 {
-		log.Fatalf("Invalid TTL (%d), must not be less than 7 or more than 22 days.", ttl)
+	// configure logging first so everything else is consistent
+	logLevel, err := parseLogLevel(c.str(envLogLevel))
+	if err != nil {
+		c.invalid(envLogLevel, "%v", err)
+	}
+	if err := logger.configure(logLevel, c.str(envLogFormat)); err != nil {
+		c.invalid(envLogFormat, "%v", err)
+	}
+	trustedProxies, err := parseAllowList(c.str(envTrustedProxies))
+	if err != nil {
+		c.invalid(envTrustedProxies, "%v", err)
+	}
+	peers, err := parsePeers(c.str(envPeers))
+	if err != nil {
+		c.invalid(envPeers, "%v", err)
 	}
 
-	// TODO: add server private key
-	// TODO: load block list from a board
-	// used for both GET and PUT
-	blockList := map[string]bool{
-		s83.InfernalKey: true,
+	host := c.str(envHost)
+	port := c.int(envPort)
+	ttl := c.int(envTTL)
+	storePath := c.str(envStore)
+	title := c.str(envTitle)
+	adminKey := c.str(envAdmin)
+
+	if c.ok(envTTL) && (ttl < 7 || ttl > 22) {
+		c.invalid(envTTL, "must not be less than 7 or more than 22 days")
 	}
 
-	// creator for the test key board
-	testCreator, err := s83.NewCreatorFromKey(s83.TestPrivate)
+	// rate limits (requests per second, 0 disables)
+	rateIP := c.float(envRateIP)
+	if c.ok(envRateIP) && rateIP < 0 {
+		c.invalid(envRateIP, "must not be negative")
+	}
+	rateKey := c.float(envRateKey)
+	if c.ok(envRateKey) && rateKey < 0 {
+		c.invalid(envRateKey, "must not be negative")
+	}
+	rateAllow, err := parseAllowList(c.str(envRateAllow))
 	if err != nil {
-		log.Fatal(err)
+		c.invalid(envRateAllow, "%v", err)
+	}
+	// a burst of 0 would reject every request
+	rateIPBurst := c.int(envRateIPBurst)
+	if c.ok(envRateIPBurst) && rateIP > 0 && rateIPBurst < 1 {
+		c.invalid(envRateIPBurst, "must be at least 1 when %s is set", envRateIP)
 	}
+	rateKeyBurst := c.int(envRateKeyBurst)
+	if c.ok(envRateKeyBurst) && rateKey > 0 && rateKeyBurst < 1 {
+		c.invalid(envRateKeyBurst, "must be at least 1 when %s is set", envRateKey)
+	}
+	limits := newRateLimits(rateIP, rateIPBurst, rateKey, rateKeyBurst, rateAllow)
 
-	// admin board
-	var admin *s83.Publisher = nil
-	adminPub, err := s83.NewPublisherFromKey(adminKey)
+	// TLS requires both a certificate and a key
+	tlsCert := c.str(envTLSCert)
+	tlsKey := c.str(envTLSKey)
+	if tlsCert != "" && tlsKey == "" {
+		c.invalid(envTLSKey, "required when %s is set", envTLSCert)
+	} else if tlsCert == "" && tlsKey != "" {
+		c.invalid(envTLSCert, "required when %s is set", envTLSKey)
+	}
+	redirectPort := c.int(envRedirectPort)
+	if redirectPort != 0 && tlsCert == "" {
+		c.invalid(envRedirectPort, "redirecting to HTTPS requires %s and %s", envTLSCert, envTLSKey)
+	}
+
+	// publishing and reading policy
+	publish := c.str(envPublish)
+	if publish != publishOpen && publish != publishAllowlist {
+		c.invalid(envPublish, "must be %s or %s", publishOpen, publishAllowlist)
+	}
+	allowFile, err := loadAllowFile(c.str(envAllowlistFile))
 	if err != nil {
-		log.Println("no admin board configured")
-	} else {
-		admin = &adminPub
-		log.Println("admin board configured for ", adminPub)
+		c.invalid(envAllowlistFile, "%v", err)
+	}
+	privateRead := c.bool(envPrivateRead)
+	if privateRead && publish != publishAllowlist {
+		c.invalid(envPrivateRead, "requires %s=%s", envPublish, publishAllowlist)
 	}
 
-	// pre load store
-	store, err := store.New(storePath)
+	denyPatterns, err := loadDenyPatterns(c.str(envDenyPatterns))
 	if err != nil {
-		log.Fatal(err)
+		c.invalid(envDenyPatterns, "%v", err)
+	}
+	maxDailyUpdates := c.int(envMaxDailyUpdates)
+	if c.ok(envMaxDailyUpdates) && maxDailyUpdates < 0 {
+		c.invalid(envMaxDailyUpdates, "must not be negative")
 	}
-	log.Printf("loaded %d boards from store %s", store.Count(), storePath)
 
-	// load templates
-	templates := template.Must(template.ParseFS(resources, "templates/*.tmpl"))
+	// hooks for accepted boards
+	hookURL := c.str(envHookURL)
+	if err := parseHookURL(hookURL); err != nil {
+		c.invalid(envHookURL, "%v", err)
+	}
+	hookWorkers := c.int(envHookWorkers)
+	if c.ok(envHookWorkers) && hookWorkers < 1 {
+		c.invalid(envHookWorkers, "must be at least 1")
+	}
+	hookQueue := c.int(envHookQueue)
+	if c.ok(envHookQueue) && hookQueue < 0 {
+		c.invalid(envHookQueue, "must not be negative")
+	}
+	hookTimeout := c.duration(envHookTimeout)
+	if c.ok(envHookTimeout) && hookTimeout == 0 {
+		c.invalid(envHookTimeout, "must be greater than 0")
+	}
+
+	// read-only mirror of another server
+	upstream, err := parseUpstream(c.str(envUpstream))
+	if err != nil {
+		c.invalid(envUpstream, "%v", err)
+	}
+	mirrorMaxAge := c.duration(envMirrorMaxAge)
+	mirrorFillRate := c.float(envMirrorFillRate)
+	if c.ok(envMirrorFillRate) && mirrorFillRate < 0 {
+		c.invalid(envMirrorFillRate, "must not be negative")
+	}
+	mirrorFillBurst := c.int(envMirrorFillBurst)
+	if c.ok(envMirrorFillBurst) && mirrorFillRate > 0 && mirrorFillBurst < 1 {
+		c.invalid(envMirrorFillBurst, "must be at least 1 when %s is set", envMirrorFillRate)
+	}
+
+	directoryPageSize := c.int(envDirectoryPageSize)
+	if c.ok(envDirectoryPageSize) && directoryPageSize < 1 {
+		c.invalid(envDirectoryPageSize, "must be at least 1")
+	}
+
+	// admin board
+	var admin *s83.Publisher = nil
+	if adminKey != "" {
+		adminPub, err := s83.NewPublisherFromKey(adminKey)
+		if err != nil {
+			c.invalid(envAdmin, "%v", err)
+		} else {
+			admin = &adminPub
+		}
+	}
 
 	srv := &Server{
-		host,
-		port,
-		store,
-		ttl,
-		title,
-		admin,
-		blockList,
-		testCreator,
-		templates,
-	}
-
-	log.Printf("board TTL: %d (days)", srv.ttl)
-	return srv
-}
+		host:        host,
+		port:        port,
+		ttl:         ttl,
+		title:       title,
+		admin:       admin,
+		adminSeen:   newSeenSignatures(adminMaxSkew),
+		readSeen:    newSeenSignatures(readMaxSkew),
+		writable:    &writableCheck{},
+		peers:       peers,
+		publish:     publish,
+		allowFile:   allowFile,
+		privateRead: privateRead,
 
-func envUsage() {
-	fmt.Println("Usage: s83d is designed to be configured using environment variables.")
-	fmt.Printf("\nFor example: `PORT=8383 ./s83d`\n\n")
-	fmt.Printf("%-16s %s\n", "variable", "default")
-	fmt.Printf("%-16s %s\n", "--------", "-------")
-	for _, name := range envVars {
-		fmt.Printf("%-16s %v\n", name, defaultVars[name])
+		validateKeys:    c.bool(envValidateKeys),
+		maxFutureSkew:   c.duration(envMaxFutureSkew),
+		denyPatterns:    denyPatterns,
+		maxDailyUpdates: maxDailyUpdates,
+
+		limits:  limits,
+		metrics: c.bool(envMetrics),
+		started: time.Now(),
+
+		readTimeout:     c.duration(envReadTimeout),
+		headerTimeout:   c.duration(envHeaderTimeout),
+		writeTimeout:    c.duration(envWriteTimeout),
+		idleTimeout:     c.duration(envIdleTimeout),
+		shutdownTimeout: c.duration(envShutdownTimeout),
+		maxHeaderBytes:  c.int(envMaxHeaderBytes),
+
+		trustedProxies: trustedProxies,
+
+		tlsCert:      tlsCert,
+		tlsKey:       tlsKey,
+		redirectPort: redirectPort,
 	}
 
-}
+	realms := c.realmSettings()
 
-func varOrDefault(name string) string {
-	envVal := os.Getenv(name)
-	if envVal != "" {
-		return envVal
+	// report every problem at once before touching the filesystem
+	if err := c.err(); err != nil {
+		log.Fatal(err)
 	}
 
-	// get default
-	val, ok := defaultVars[name]
-	if !ok {
-		log.Fatalf("Attempted to get variable with no default from ENV: %s", name)
+	if path := c.str(envAccessLog); path != "" {
+		srv.accessLog, err = openAccessLog(path)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", envAccessLog, err)
+		}
 	}
-	return val
-}
 
-func intOrDefault(name string) int {
-	nStr := varOrDefault(name)
-	n, err := strconv.Atoi(nStr)
+	// TODO: load block list from a board
+	// used for both GET and PUT
+	srv.blockList = defaultBlockList()
+
+	// creator for the test key board
+	srv.testCreator, err = s83.NewCreatorFromKey(s83.TestPrivate)
 	if err != nil {
-		log.Fatalf("failed parsing int for var: %s: %s: %v\n", name, nStr, err)
+		log.Fatal(err)
 	}
-	return n
-}
 
-func floatOrDefault(name string) float64 {
-	fStr := varOrDefault(name)
-	f, err := strconv.ParseFloat(fStr, 64)
+	if admin == nil {
+		logger.Info("no admin board configured")
+	} else {
+		logger.Info("admin board configured", "key", admin)
+	}
+
+	// pre load store, locked first so offline admin commands can't change
+	// boards underneath the server
+	srv.lock, err = store.LockDir(storePath)
+	if err != nil {
+		log.Fatalf("Invalid %s: locking store: %v", envStore, err)
+	}
+	srv.store, err = store.New(storePath)
 	if err != nil {
-		log.Fatalf("failed parsing float for var: %s: %s: %v\n", name, fStr, err)
+		log.Fatalf("Invalid %s: %v", envStore, err)
 	}
-	return f
+	logger.Info("loaded store", "boards", srv.store.Count(), "path", storePath)
+	if err := srv.quarantineFutureBoards(); err != nil {
+		log.Fatalf("Invalid %s: %v", envStore, err)
+	}
+
+	// server identity
+	keyPath := c.str(envServerKey)
+	if keyPath == "" {
+		keyPath = filepath.Join(storePath, serverKeyFile)
+	}
+	srv.identity, err = loadIdentity(keyPath)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", envServerKey, err)
+	}
+	logger.Info("server identity", "key", srv.identity)
+
+	// stream changes to subscribers
+	srv.events = newBroker()
+	srv.store.OnChange(srv.events.publish)
+
+	// run hooks for accepted boards
+	srv.hooks = newHooks(c.str(envHookCommand), hookURL, hookWorkers, hookQueue, hookTimeout)
+	if srv.hooks != nil {
+		srv.store.OnChange(srv.hooks.enqueue)
+		logger.Info("hooks configured", "command", c.str(envHookCommand), "url", hookURL, "workers", hookWorkers)
+	}
+
+	if upstream != nil {
+		srv.mirror = newMirror(upstream, mirrorMaxAge, mirrorFillRate, mirrorFillBurst)
+		logger.Info("mirroring upstream server (read-only)", "upstream", upstream, "max_age", mirrorMaxAge.String())
+	}
+
+	// decide which boards are accepted
+	srv.admission = srv.putPolicies()
+	logger.Info("PUT policies", "policies", srv.policyNames())
+
+	// load templates
+	srv.templates = parseTemplates()
+
+	// realms share everything configured above except their own settings
+	srv.realms = map[string]*Server{}
+	for _, rs := range realms {
+		if err := srv.addRealm(rs); err != nil {
+			log.Fatalf("Invalid %s for realm %s: %v", envStore, rs.host, err)
+		}
+	}
+
+	if publish == publishAllowlist {
+		logger.Info("publishing limited to allowed keys", "file_keys", len(allowFile), "private_read", privateRead)
+	}
+	logger.Info("board TTL", "days", srv.ttl)
+	return srv
+}
+
+func envUsage() {
+	fmt.Println("Usage: s83d is designed to be configured using environment variables.")
+	fmt.Printf("\nFor example: `PORT=8383 ./s83d`\n\n")
+	fmt.Println("Every variable can also be set in a config file (-config) or with a flag")
+	fmt.Printf("(e.g. -port 8383). Flags override the environment, which overrides the file.\n\n")
+	fmt.Printf("%-20s %-8s %s\n", "variable", "default", "description")
+	fmt.Printf("%-20s %-8s %s\n", "--------", "-------", "-----------")
+	for _, name := range envVars {
+		fmt.Printf("%-20s %-8v %s\n", name, defaultVars[name], varHelp[name])
+	}
+
+	fmt.Println("\ncommands (run instead of the server):")
+	fmt.Printf("  %-14s %s\n", "admin", "manage the stored boards offline (see `s83d admin`)")
+	fmt.Printf("  %-14s %s\n", "export-site", "write the stored boards to a directory as a static site")
+
+	fmt.Println("\nflags:")
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, setting := defaultVars[confName(f.Name)]; setting {
+			return
+		}
+		fmt.Printf("  -%-14s %s\n", f.Name, f.Usage)
+	})
 }
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/royragsdale/s83"
+	"github.com/royragsdale/s83/store"
+)
+
+// Clients can subscribe to board changes with Server-Sent Events instead of
+// polling every key.
+// ref: https://html.spec.whatwg.org/multipage/server-sent-events.html
+const eventsPath = "/events"
+
+const (
+	eventsMaxKeys   = 100              // keys per subscription
+	eventsBuffer    = 16               // queued events per subscriber
+	eventsHeartbeat = 30 * time.Second // keep idle connections (and proxies) alive
+	eventsRetry     = time.Second      // reconnection delay suggested to clients
+)
+
+var reEventKey = regexp.MustCompile(`^[0-9A-Fa-f]{64}$`)
+
+// eventData is the JSON payload of an event. Clients should verify the
+// content against the signature as they would any board.
+type eventData struct {
+	Key       string `json:"key"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Content   string `json:"content,omitempty"`
+}
+
+type subscriber struct {
+	keys map[string]bool
+	ch   chan store.Change
+}
+
+// broker fans out store changes to subscribers. Events are numbered by the
+// store's changes sequence, so a subscriber that falls behind (and is
+// disconnected) or loses its connection, even across a restart, can reconnect
+// and resume with Last-Event-ID.
+type broker struct {
+	mu     sync.Mutex
+	subs   map[*subscriber]bool
+	done   chan struct{} // closed on shutdown
+	closed bool
+}
+
+func newBroker() *broker {
+	return &broker{subs: map[*subscriber]bool{}, done: make(chan struct{})}
+}
+
+// publish is registered as a store change hook
+func (b *broker) publish(c store.Change) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		if !sub.keys[c.Key] {
+			continue
+		}
+		select {
+		case sub.ch <- c:
+		default:
+			// never block the store on a slow subscriber
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// subscribe registers interest in keys
+func (b *broker) subscribe(keys map[string]bool) *subscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &subscriber{keys, make(chan store.Change, eventsBuffer)}
+	b.subs[sub] = true
+	return sub
+}
+
+func (b *broker) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// count is the number of current subscribers
+func (b *broker) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// close ends every stream (on shutdown)
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+}
+
+// eventKeys parses the keys to subscribe to from (repeated or comma
+// separated) ?key= query parameters
+func eventKeys(req *http.Request) (map[string]bool, error) {
+	keys := map[string]bool{}
+	for _, param := range req.URL.Query()["key"] {
+		for _, key := range strings.Split(param, ",") {
+			key = strings.ToLower(strings.TrimSpace(key))
+			if !reEventKey.MatchString(key) {
+				return nil, fmt.Errorf("invalid key: %s", key)
+			}
+			keys[key] = true
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one key is required (e.g. ?key=<key>)")
+	}
+	if len(keys) > eventsMaxKeys {
+		return nil, fmt.Errorf("at most %d keys per subscription", eventsMaxKeys)
+	}
+	return keys, nil
+}
+
+// handleEvents streams changes to the requested boards until the client
+// disconnects. Clients that reconnect with Last-Event-ID first get the latest
+// change to each board since then, from the store's changes.
+func (srv *Server) handleEvents(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet {
+		return newHTTPError(http.StatusMethodNotAllowed, "use GET")
+	}
+
+	keys, err := eventKeys(req)
+	if err != nil {
+		return newHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// the server's write timeout would end the stream, instead it limits each
+	// write (with no deadline while waiting between them)
+	rc := http.NewResponseController(w)
+	deadline := func(d time.Time) error {
+		if srv.writeTimeout <= 0 {
+			return nil
+		}
+		return rc.SetWriteDeadline(d)
+	}
+	write := func(fn func()) error {
+		if err := deadline(time.Now().Add(srv.writeTimeout)); err != nil {
+			return err
+		}
+		fn()
+		if err := rc.Flush(); err != nil {
+			return err
+		}
+		return deadline(time.Time{})
+	}
+	if err := deadline(time.Time{}); err != nil {
+		return newHTTPErrorLog(http.StatusInternalServerError, "streaming unsupported", err)
+	}
+
+	// subscribe before replaying, so no change is missed in between
+	sub := srv.events.subscribe(keys)
+	defer srv.events.unsubscribe(sub)
+	lastID, _ := strconv.ParseUint(req.Header.Get("Last-Event-ID"), 10, 64)
+	replay := []store.Change{}
+	if lastID > 0 {
+		for _, e := range srv.store.Changes(lastID, math.MaxInt) {
+			if keys[e.Key] {
+				replay = append(replay, store.Change{Seq: e.Seq, Key: e.Key, Board: e.Board, Removed: e.Removed})
+				lastID = e.Seq
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	err = write(func() {
+		fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+		for _, c := range replay {
+			srv.writeEvent(w, c)
+		}
+	})
+	if err != nil {
+		return nil
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return nil
+		case <-srv.events.done:
+			return nil
+		case <-heartbeat.C:
+			err = write(func() { fmt.Fprint(w, ": heartbeat\n\n") })
+		case c, ok := <-sub.ch:
+			if !ok {
+				// fell behind, the client will reconnect
+				return nil
+			}
+			// already replayed
+			if c.Seq <= lastID {
+				continue
+			}
+			err = write(func() { srv.writeEvent(w, c) })
+		}
+		if err != nil {
+			// the client stopped reading
+			return nil
+		}
+	}
+}
+
+// writeEvent writes a "board" event for an update or a "remove" event
+func (srv *Server) writeEvent(w http.ResponseWriter, c store.Change) {
+	if srv.blocked(c.Key) {
+		return
+	}
+
+	name := "remove"
+	data := eventData{Key: c.Key}
+	if !c.Removed {
+		name = "board"
+		data.Timestamp = c.Board.Time().UTC().Format(s83.TimeFormat8601)
+		data.Signature = c.Board.Signature()
+		data.Content = c.Board.String()
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		logger.Error("failed encoding event", "error", err)
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", c.Seq, name, payload)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/royragsdale/s83"
+	"github.com/royragsdale/s83/store"
+)
+
+const tSite = "site.html.tmpl"
+
+// names of the files exported for each board, in a directory named by its key
+const (
+	exportPage = "index.html"
+	exportRaw  = "board.txt" // the content exactly as signed (never served as a page)
+	exportSig  = exportRaw + ".sig"
+)
+
+type siteData struct {
+	Title     string
+	Boards    []s83.Board
+	Exported  string
+	Source    string // the server the boards were exported from (if known)
+	ClientCSS template.CSS
+	Nonce     string // allows the page's own scripts (see boardCSP)
+}
+
+// exportSite writes every board served from the store to dir as a static site:
+// an index of listed boards, a page for each board, and each board's signed
+// content and signature. dir must be empty (or not exist yet). It returns the
+// number of boards exported.
+func (srv *Server) exportSite(dir string, source string) (int, error) {
+	if err := emptyDir(dir); err != nil {
+		return 0, err
+	}
+	// static hosts won't send a Content-Security-Policy, so pages carry their
+	// own (see boardCSP)
+	nonce, err := newNonce()
+	if err != nil {
+		return 0, err
+	}
+
+	exported := map[string]bool{}
+	for _, board := range srv.store.Boards() {
+		if srv.blocked(board.Key()) || srv.boardExpired(board) {
+			continue
+		}
+		// never served, so never exported
+		if !board.VerifySignature() {
+			logger.Warn("skipping board that failed signature validation", "key", board.Key())
+			continue
+		}
+		if err := srv.exportBoard(dir, board, source, nonce); err != nil {
+			return len(exported), fmt.Errorf("failed exporting %s: %w", board.Key(), err)
+		}
+		exported[board.Key()] = true
+	}
+
+	listed := []s83.Board{}
+	for _, board := range srv.recentBoards() {
+		if exported[board.Key()] {
+			listed = append(listed, board)
+		}
+	}
+	data := siteData{
+		srv.title,
+		listed,
+		time.Now().UTC().Format(s83.TimeFormat8601),
+		source,
+		s83.ClientCSS,
+		nonce,
+	}
+	return len(exported), writeTemplate(filepath.Join(dir, exportPage), srv.templates, tSite, data)
+}
+
+// exportBoard writes a board's page, signed content and signature
+func (srv *Server) exportBoard(dir string, board s83.Board, source string, nonce string) error {
+	boardDir := filepath.Join(dir, board.Key())
+	if err := os.MkdirAll(boardDir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(boardDir, exportRaw), board.Content, 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(boardDir, exportSig), []byte(board.Signature()+"\n"), 0644); err != nil {
+		return err
+	}
+
+	follow := ""
+	if source != "" {
+		follow = fmt.Sprintf("%s\n%s/%s", board.Key()[:8], strings.TrimSuffix(source, "/"), board.Key())
+	}
+	data := boardData{
+		srv.title,
+		board,
+		follow,
+		s83.ClientCSS,
+		"../",
+		false,
+		exportRaw,
+		nonce,
+	}
+	return writeTemplate(filepath.Join(boardDir, exportPage), srv.templates, tBoard, data)
+}
+
+func writeTemplate(path string, t *template.Template, name string, data interface{}) error {
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// emptyDir makes sure dir exists and is empty, so an export never mixes with
+// (or clobbers) other files
+func emptyDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Readdirnames(1); !errors.Is(err, io.EOF) {
+		if err == nil {
+			err = fmt.Errorf("not empty: %s", dir)
+		}
+		return err
+	}
+	return nil
+}
+
+// exportServer sets up only what an export needs: the store, opened read only
+// (so exporting never changes it, and can run alongside the server), the
+// settings deciding which boards are served, and the templates
+func exportServer(c *conf) (*Server, error) {
+	dir, ttl, title := c.str(envStore), c.int(envTTL), c.str(envTitle)
+	if err := c.err(); err != nil {
+		return nil, err
+	}
+	st, err := store.NewReadOnly(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		store:     st,
+		ttl:       ttl,
+		title:     title,
+		blockList: defaultBlockList(),
+		templates: parseTemplates(),
+	}, nil
+}
+
+// exportSiteCmd runs `s83d export-site [-url <server>] <dir>`
+func exportSiteCmd(c *conf, args []string) error {
+	fs := flag.NewFlagSet("export-site", flag.ExitOnError)
+	sourceFlag := fs.String("url", "", "URL of the server the boards are from (to show how to follow them)")
+	fs.Usage = func() {
+		fmt.Println("usage: s83d [flags] export-site [-url <server>] <dir>")
+		fmt.Println("\nWrites the boards in STORE to an empty directory as a static HTML site.")
+		fmt.Println("\nflags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *sourceFlag != "" {
+		if _, err := parseUpstream(*sourceFlag); err != nil {
+			return fmt.Errorf("invalid -url: %w", err)
+		}
+	}
+
+	srv, err := exportServer(c)
+	if err != nil {
+		return err
+	}
+	n, err := srv.exportSite(fs.Arg(0), *sourceFlag)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("exported %d boards to %s\n", n, fs.Arg(0))
+	return nil
+}
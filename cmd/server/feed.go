@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/royragsdale/s83"
+)
+
+// the server-wide feed only includes the most recently updated boards
+const feedMaxEntries = 50
+
+const (
+	atomContentType = "application/atom+xml;charset=utf-8"
+	jsonContentType = "application/feed+json;charset=utf-8"
+)
+
+// siteURL is the base URL this server is reached at
+func (srv *Server) siteURL(req *http.Request) string {
+	return strings.TrimSuffix(srv.boardURL(req, ""), "/")
+}
+
+// handleBoardFeed serves a single board as an Atom or JSON feed
+func (srv *Server) handleBoardFeed(w http.ResponseWriter, req *http.Request, key string, asJSON bool) error {
+	board, err := srv.lookupBoard(req, key)
+	if err != nil {
+		return err
+	}
+
+	ext := ".atom"
+	if asJSON {
+		ext = ".json"
+	}
+	boardURL := srv.boardURL(req, key)
+	feed := s83.Feed{
+		Title:   fmt.Sprintf("%s (%s)", key[:8], srv.title),
+		Link:    boardURL,
+		FeedURL: boardURL + ext,
+		Items:   []s83.FeedItem{{Board: board, URL: boardURL}},
+	}
+	return writeFeed(w, feed, asJSON)
+}
+
+// handleServerFeed serves the recently updated (listed) boards as a feed, when
+// the directory is enabled
+func (srv *Server) handleServerFeed(w http.ResponseWriter, req *http.Request, asJSON bool) error {
+	// the feed lists boards, so only when the operator lists them
+	if !srv.directoryEnabled {
+		return newHTTPError(http.StatusNotFound, "directory disabled")
+	}
+	boards := srv.recentBoards()
+	if len(boards) > feedMaxEntries {
+		boards = boards[:feedMaxEntries]
+	}
+
+	feed := s83.Feed{
+		Title:   srv.title,
+		Link:    srv.siteURL(req) + "/",
+		FeedURL: srv.siteURL(req) + req.URL.Path,
+	}
+	for _, b := range boards {
+		feed.Items = append(feed.Items, s83.FeedItem{Board: b, URL: srv.boardURL(req, b.Key())})
+	}
+	return writeFeed(w, feed, asJSON)
+}
+
+func writeFeed(w http.ResponseWriter, feed s83.Feed, asJSON bool) error {
+	render, contentType := feed.Atom, atomContentType
+	if asJSON {
+		render, contentType = feed.JSON, jsonContentType
+	}
+
+	data, err := render()
+	if err != nil {
+		return newHTTPErrorLog(http.StatusInternalServerError, "failed rendering feed", err)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+	return nil
+}
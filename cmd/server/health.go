@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/royragsdale/s83/store"
+)
+
+// liveness and readiness probes (e.g. for container orchestration). They
+// are cheap, unlike the homepage which renders a test board on every request.
+const (
+	healthPath = "/healthz"
+	readyPath  = "/readyz"
+)
+
+// writableInterval is how long a store writable check is reused, so frequent
+// probes don't each create a file
+const writableInterval = 5 * time.Second
+
+type healthStatus struct {
+	Status string `json:"status"`
+	Uptime string `json:"uptime"`
+}
+
+type readyCheck struct {
+	Name   string `json:"name"`
+	Realm  string `json:"realm,omitempty"` // empty for the default realm
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+type readyStatus struct {
+	Status string       `json:"status"`
+	Checks []readyCheck `json:"checks"`
+}
+
+// handleHealth reports the process is alive and serving requests
+func (srv *Server) handleHealth(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return newHTTPError(http.StatusMethodNotAllowed, "use GET")
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, healthStatus{"ok", time.Since(srv.started).Round(time.Second).String()})
+}
+
+// handleReady reports whether every realm can serve and store boards,
+// responding 503 if any check fails
+func (srv *Server) handleReady(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return newHTTPError(http.StatusMethodNotAllowed, "use GET")
+	}
+
+	status := readyStatus{"ready", srv.readyChecks("")}
+	hosts := make([]string, 0, len(srv.realms))
+	for host := range srv.realms {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		status.Checks = append(status.Checks, srv.realms[host].readyChecks(host)...)
+	}
+
+	// headers must be set before the status is written
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	for _, check := range status.Checks {
+		if !check.OK {
+			status.Status = "not ready"
+			w.WriteHeader(http.StatusServiceUnavailable)
+			logger.Warn("not ready", "check", check.Name, "realm", check.Realm, "detail", check.Detail)
+			break
+		}
+	}
+	return json.NewEncoder(w).Encode(status)
+}
+
+// readyChecks checks a realm's store and templates
+func (srv *Server) readyChecks(realm string) []readyCheck {
+	checks := []readyCheck{}
+
+	store := readyCheck{Name: "store", Realm: realm, OK: srv.store != nil, Detail: "not loaded"}
+	if store.OK {
+		if err := srv.store.Readable(); err != nil {
+			store.OK, store.Detail = false, err.Error()
+		} else {
+			store.Detail = fmt.Sprintf("%d boards", srv.store.Count())
+		}
+	}
+	checks = append(checks, store)
+
+	writable := readyCheck{Name: "store_writable", Realm: realm, OK: srv.store != nil, Detail: "not loaded"}
+	if writable.OK {
+		if err := srv.writable.check(srv.store); err != nil {
+			writable.OK, writable.Detail = false, err.Error()
+		} else {
+			writable.Detail = "ok"
+		}
+	}
+	checks = append(checks, writable)
+
+	templates := readyCheck{Name: "templates", Realm: realm, OK: true, Detail: "ok"}
+	for _, name := range []string{tIndex, tTest, tBoard, tSite} {
+		if srv.templates == nil || srv.templates.Lookup(name) == nil {
+			templates.OK, templates.Detail = false, "missing "+name
+			break
+		}
+	}
+	return append(checks, templates)
+}
+
+// writableCheck reuses the result of checking a store is writable for
+// writableInterval
+type writableCheck struct {
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func (c *writableCheck) check(st *store.Store) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) >= writableInterval {
+		c.err = st.Writable()
+		c.checked = time.Now()
+	}
+	return c.err
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/royragsdale/s83"
+	"github.com/royragsdale/s83/store"
+)
+
+// at most this much of a failed hook's output is logged
+const hookMaxOutput = 1024
+
+// hookEvent is sent (as JSON) to hooks for every accepted board
+type hookEvent struct {
+	Key       string `json:"key"`
+	Timestamp string `json:"timestamp"`
+	Signature string `json:"signature"`
+}
+
+// hooks run a local command and/or POST to a URL for every accepted board. A
+// fixed pool of workers runs them from a bounded queue, when the queue is full
+// events are dropped (and logged) rather than holding up a PUT.
+type hooks struct {
+	command []string // program and arguments (run without a shell)
+	url     string
+	timeout time.Duration
+	client  *http.Client
+
+	mu     sync.Mutex
+	queue  chan hookEvent
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// newHooks starts the workers, it returns nil if no hook is configured
+func newHooks(command string, hookURL string, workers int, queueLen int, timeout time.Duration) *hooks {
+	if command == "" && hookURL == "" {
+		return nil
+	}
+	h := &hooks{
+		command: strings.Fields(command),
+		url:     hookURL,
+		timeout: timeout,
+		client:  &http.Client{},
+		queue:   make(chan hookEvent, queueLen),
+	}
+	for i := 0; i < workers; i++ {
+		h.wg.Add(1)
+		go h.work()
+	}
+	return h
+}
+
+// parseHookURL checks a webhook URL
+func parseHookURL(hookURL string) error {
+	if hookURL == "" {
+		return nil
+	}
+	u, err := url.Parse(hookURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("not an http(s) URL: %s", hookURL)
+	}
+	return nil
+}
+
+// enqueue queues an event for an accepted board without blocking (it is
+// registered as a store change hook). Only boards published to this server
+// run hooks: not removals, quarantined boards or boards copied by the mirror.
+func (h *hooks) enqueue(c store.Change) {
+	if c.Source != store.SourceAdd {
+		return
+	}
+	ev := hookEvent{c.Key, c.Board.Time().UTC().Format(s83.TimeFormat8601), c.Board.Signature()}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	select {
+	case h.queue <- ev:
+	default:
+		metrics.hooks.inc("queue", "dropped")
+		logger.Warn("hook queue full, dropped event", "key", c.Key)
+	}
+}
+
+func (h *hooks) work() {
+	defer h.wg.Done()
+	for ev := range h.queue {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			logger.Error("failed encoding hook event", "key", ev.Key, "error", err)
+			continue
+		}
+		if len(h.command) > 0 {
+			h.report("command", ev, h.runCommand(data))
+		}
+		if h.url != "" {
+			h.report("url", ev, h.post(data))
+		}
+	}
+}
+
+func (h *hooks) report(hook string, ev hookEvent, err error) {
+	if err != nil {
+		metrics.hooks.inc(hook, "error")
+		logger.Warn("hook failed", "hook", hook, "key", ev.Key, "error", err)
+		return
+	}
+	metrics.hooks.inc(hook, "ok")
+	logger.Debug("hook ran", "hook", hook, "key", ev.Key)
+}
+
+// runCommand runs the command with the event on stdin. On timeout its whole
+// process group is killed, so a child left running can't hold the output open
+// (and the worker) past the timeout.
+func (h *hooks) runCommand(data []byte) error {
+	var out bytes.Buffer
+	cmd := exec.Command(h.command[0], h.command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := startGroup(cmd); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	timeout := time.NewTimer(h.timeout)
+	defer timeout.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, truncate(out.Bytes(), hookMaxOutput))
+		}
+		return nil
+	case <-timeout.C:
+		killGroup(cmd)
+		<-done
+		return fmt.Errorf("timed out after %s", h.timeout)
+	}
+}
+
+// post sends the event as the body of a POST to the URL
+func (h *hooks) post(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Spring-Version", s83.SpringVersion)
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, hookMaxOutput))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// close stops accepting events and waits (until ctx is done) for queued
+// events to be run
+func (h *hooks) close(ctx context.Context) {
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.queue)
+	}
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logger.Warn("gave up waiting for hooks", "queued", len(h.queue))
+	}
+}
+
+func truncate(b []byte, n int) []byte {
+	b = bytes.TrimSpace(b)
+	if len(b) > n {
+		return b[:n]
+	}
+	return b
+}
@@ -0,0 +1,14 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+import "os/exec"
+
+// no process groups, only the command itself is started and killed
+func startGroup(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
+
+func killGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/royragsdale/s83"
+)
+
+// The server identity key is kept in the store directory unless SERVER_KEY
+// points elsewhere. It is generated on first start.
+const serverKeyFile = "server.key"
+
+// loadIdentity reads the server's ed25519 key (a hex seed) from path,
+// generating and saving a new one if the file does not exist
+func loadIdentity(path string) (s83.Creator, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return newIdentity(path)
+	} else if err != nil {
+		return s83.Creator{}, err
+	}
+	return s83.NewCreatorFromKey(strings.TrimSpace(string(data)))
+}
+
+func newIdentity(path string) (s83.Creator, error) {
+	// the identity is not a board key so it doesn't need mining
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return s83.Creator{}, err
+	}
+	c := s83.Creator{PrivateKey: priv, Publisher: s83.Publisher{PublicKey: pub}}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return s83.Creator{}, err
+	}
+	if _, err := fmt.Fprintln(f, c.ExportPrivateKey()); err != nil {
+		f.Close()
+		return s83.Creator{}, err
+	}
+	logger.Info("generated server identity key", "key", c, "path", path)
+	return c, f.Close()
+}
+
+// parsePeers parses a comma separated list of peer server URLs
+func parsePeers(list string) ([]string, error) {
+	peers := []string{}
+	for _, peer := range strings.Split(list, ",") {
+		peer = strings.TrimSpace(peer)
+		if peer == "" {
+			continue
+		}
+		u, err := url.Parse(peer)
+		if err != nil {
+			return nil, err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("not an http(s) URL: %s", peer)
+		}
+		peers = append(peers, u.String())
+	}
+	return peers, nil
+}
+
+// policies describes how the server treats clients (advertised in the
+// server info document)
+func (srv *Server) policies() map[string]string {
+	rate := func(l limiterState) string {
+		if !l.Enabled {
+			return "off"
+		}
+		return fmt.Sprintf("%s/s burst %d", strconv.FormatFloat(l.Rate, 'f', -1, 64), l.Burst)
+	}
+	admin := "off"
+	if srv.admin != nil {
+		admin = "signed"
+	}
+	read := "public"
+	if srv.privateRead {
+		read = "signed"
+	}
+	publish := srv.publish
+	if srv.mirror != nil {
+		publish = "mirror of " + srv.mirror.upstream.String()
+	}
+	return map[string]string{
+		"publish":        publish,
+		"read":           read,
+		"rate_limit_ip":  rate(srv.limits.ip.state()),
+		"rate_limit_key": rate(srv.limits.key.state()),
+		"directory":      strconv.FormatBool(srv.directoryEnabled),
+		"admin_api":      admin,
+		"put":            srv.policyNames(),
+	}
+}
+
+// handleServerInfo serves the signed server info document
+func (srv *Server) handleServerInfo(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet {
+		return newHTTPError(http.StatusMethodNotAllowed, "use GET")
+	}
+
+	info := s83.ServerInfo{
+		Title:         srv.title,
+		SpringVersion: s83.SpringVersion,
+		TTL:           srv.ttl,
+		MaxBoardLen:   s83.MaxBoardLen,
+		Peers:         srv.peers,
+		Policies:      srv.policies(),
+	}
+	if srv.admin != nil {
+		info.AdminBoard = srv.admin.String()
+	}
+
+	data, err := srv.identity.SignServerInfo(info)
+	if err != nil {
+		return newHTTPErrorLog(http.StatusInternalServerError, "failed signing server info", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+	return nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header used to echo the per-request ID back to clients
+const requestIDHeader = "X-Request-Id"
+
+// Common Log Format timestamp
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+func parseLogLevel(s string) (logLevel, error) {
+	for level, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return level, nil
+		}
+	}
+	return levelInfo, fmt.Errorf("unknown log level: %s (use debug, info, warn or error)", s)
+}
+
+// logOutput is shared by a logger and every logger derived from it
+type logOutput struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  logLevel
+	format string // "logfmt" or "json"
+}
+
+// levelLogger writes structured, levelled log lines. Fields are given as
+// alternating key value pairs.
+type levelLogger struct {
+	output *logOutput
+	fields []interface{}
+}
+
+func newLogger(out io.Writer, level logLevel, format string) *levelLogger {
+	return &levelLogger{&logOutput{out: out, level: level, format: format}, nil}
+}
+
+// shared by every handler (srvHandler has no access to the Server)
+var logger = newLogger(os.Stderr, levelInfo, "logfmt")
+
+func (l *levelLogger) configure(level logLevel, format string) error {
+	if format != "logfmt" && format != "json" {
+		return fmt.Errorf("unknown log format: %s (use logfmt or json)", format)
+	}
+	l.output.mu.Lock()
+	defer l.output.mu.Unlock()
+	l.output.level = level
+	l.output.format = format
+	return nil
+}
+
+// with returns a logger that adds fields to every line
+func (l *levelLogger) with(kv ...interface{}) *levelLogger {
+	fields := append(append([]interface{}{}, l.fields...), kv...)
+	return &levelLogger{l.output, fields}
+}
+
+func (l *levelLogger) Debug(msg string, kv ...interface{}) { l.log(levelDebug, msg, kv) }
+func (l *levelLogger) Info(msg string, kv ...interface{})  { l.log(levelInfo, msg, kv) }
+func (l *levelLogger) Warn(msg string, kv ...interface{})  { l.log(levelWarn, msg, kv) }
+func (l *levelLogger) Error(msg string, kv ...interface{}) { l.log(levelError, msg, kv) }
+
+// Fatal logs at the error level and exits
+func (l *levelLogger) Fatal(msg string, kv ...interface{}) {
+	l.log(levelError, msg, kv)
+	os.Exit(1)
+}
+
+func (l *levelLogger) log(level logLevel, msg string, kv []interface{}) {
+	o := l.output
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if level < o.level {
+		return
+	}
+
+	fields := append([]interface{}{
+		"time", time.Now().UTC().Format(time.RFC3339),
+		"level", levelNames[level],
+		"msg", msg,
+	}, l.fields...)
+	fields = append(fields, kv...)
+
+	var line []byte
+	if o.format == "json" {
+		line = formatJSON(fields)
+	} else {
+		line = formatLogfmt(fields)
+	}
+	o.out.Write(append(line, '\n'))
+}
+
+func fieldValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case error:
+		return val.Error()
+	case fmt.Stringer:
+		return val.String()
+	case time.Duration:
+		return val.Seconds()
+	}
+	return v
+}
+
+func formatJSON(fields []interface{}) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i := 0; i+1 < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(fmt.Sprint(fields[i]))
+		v, err := json.Marshal(fieldValue(fields[i+1]))
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(fields[i+1]))
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+func formatLogfmt(fields []interface{}) []byte {
+	var buf bytes.Buffer
+	for i := 0; i+1 < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteByte('=')
+		v := fmt.Sprint(fieldValue(fields[i+1]))
+		if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+			v = strconv.Quote(v)
+		}
+		buf.WriteString(v)
+	}
+	return buf.Bytes()
+}
+
+/* per request context */
+
+// requestInfo is attached to every request so handlers can log consistently
+type requestInfo struct {
+	id  string
+	ip  net.IP
+	key string // set by board handlers
+}
+
+type ctxKey int
+
+const requestInfoKey ctxKey = 0
+
+// reqInfo returns the info attached to a request (or a fresh one)
+func reqInfo(req *http.Request) *requestInfo {
+	if info, ok := req.Context().Value(requestInfoKey).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{ip: remoteAddrIP(req)}
+}
+
+// reqLog returns a logger that tags lines with the request ID
+func reqLog(req *http.Request) *levelLogger {
+	if info := reqInfo(req); info.id != "" {
+		return logger.with("request_id", info.id)
+	}
+	return logger
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// logRequests assigns each request an ID and client address, and logs it on
+// completion (plus an optional access log in Common Log Format)
+func (srv *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+
+		info := &requestInfo{id: newRequestID(), ip: srv.clientIP(req)}
+		req = req.WithContext(context.WithValue(req.Context(), requestInfoKey, info))
+		rw.Header().Set(requestIDHeader, info.id)
+
+		w := newStatusRecorder(rw)
+		next.ServeHTTP(w, req)
+		elapsed := time.Since(start)
+
+		fields := []interface{}{
+			"request_id", info.id,
+			"ip", info.ip,
+			"method", req.Method,
+			"path", req.URL.Path,
+			"status", w.code,
+			"bytes", w.bytes,
+			"duration", elapsed,
+		}
+		if info.key != "" {
+			fields = append(fields, "key", info.key)
+		}
+		logger.Info("request", fields...)
+
+		if srv.accessLog != nil {
+			srv.accessLog.write(req, info, w, start)
+		}
+	})
+}
+
+// accessLog writes requests in Common Log Format
+type accessLog struct {
+	mu  sync.Mutex
+	out io.WriteCloser
+}
+
+func openAccessLog(path string) (*accessLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLog{out: f}, nil
+}
+
+// write appends a line: host ident authuser [date] "request" status bytes
+func (al *accessLog) write(req *http.Request, info *requestInfo, w *statusRecorder, start time.Time) {
+	host := "-"
+	if info.ip != nil {
+		host = info.ip.String()
+	}
+	line := fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %d\n",
+		host, start.Format(clfTimeFormat), req.Method, req.URL.RequestURI(), req.Proto, w.code, w.bytes)
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if _, err := io.WriteString(al.out, line); err != nil {
+		logger.Error("failed writing access log", "error", err)
+	}
+}
+
+func (al *accessLog) close() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return al.out.Close()
+}
+
+// clientIP resolves the address of the client. When the request comes from a
+// trusted proxy the X-Forwarded-For chain is walked from the right, skipping
+// trusted proxies, to find the original client.
+func (srv *Server) clientIP(req *http.Request) net.IP {
+	ip := remoteAddrIP(req)
+	if ip == nil || !containsIP(srv.trustedProxies, ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !containsIP(srv.trustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// remoteAddrIP is the address of the peer that connected to the server
+func remoteAddrIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
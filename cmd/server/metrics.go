@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are exposed in the Prometheus text exposition format (v0.0.4).
+// ref: https://prometheus.io/docs/instrumenting/exposition_formats/
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// request latency buckets (seconds)
+var latencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// counterVec is a family of counters partitioned by label values
+type counterVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64 // joined label values -> count
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+// inc increments the counter for the given label values (in label order)
+func (c *counterVec) inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(labelValues, "\xff")] += 1
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var values []string
+		if len(c.labels) > 0 {
+			values = strings.Split(k, "\xff")
+		}
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, values), formatFloat(c.values[k]))
+	}
+}
+
+// histogram tracks the distribution of observations in cumulative buckets
+type histogram struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	buckets []float64
+	counts  []uint64 // per bucket (not cumulative)
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name string, help string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.buckets) {
+		h.counts[i] += 1
+	}
+	h.sum += v
+	h.count += 1
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(le), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+// serverMetrics are the metrics collected while handling requests
+type serverMetrics struct {
+	requests    *counterVec
+	puts        *counterVec
+	sigFailures *counterVec
+	blocked     *counterVec
+	rejected    *counterVec
+	hooks       *counterVec
+	mirror      *counterVec
+	duration    *histogram
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests:    newCounterVec("s83d_http_requests_total", "HTTP requests by method and status code.", "method", "code"),
+		puts:        newCounterVec("s83d_board_puts_total", "Board PUT outcomes by status code.", "code"),
+		sigFailures: newCounterVec("s83d_signature_failures_total", "Boards rejected for an invalid signature."),
+		blocked:     newCounterVec("s83d_blocked_requests_total", "Requests for blocked keys by method.", "method"),
+		rejected:    newCounterVec("s83d_put_rejected_total", "Boards rejected by a PUT policy.", "policy"),
+		hooks:       newCounterVec("s83d_hook_runs_total", "Hooks run for accepted boards by hook and result.", "hook", "result"),
+		mirror:      newCounterVec("s83d_mirror_fetches_total", "Upstream board fetches by result.", "result"),
+		duration:    newHistogram("s83d_http_request_duration_seconds", "HTTP request latency.", latencyBuckets),
+	}
+}
+
+// shared by every handler (srvHandler has no access to the Server)
+var metrics = newServerMetrics()
+
+// observe records a completed request
+func (m *serverMetrics) observe(method string, code int, elapsed time.Duration) {
+	method = knownMethod(method)
+	m.requests.inc(method, strconv.Itoa(code))
+	if method == http.MethodPut {
+		m.puts.inc(strconv.Itoa(code))
+	}
+	m.duration.observe(elapsed.Seconds())
+}
+
+// knownMethod bounds label cardinality to the methods the server supports
+func knownMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+func (srv *Server) handleMetrics(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet {
+		return newHTTPError(http.StatusMethodNotAllowed, "use GET")
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+
+	metrics.requests.write(w)
+	metrics.puts.write(w)
+	metrics.sigFailures.write(w)
+	metrics.blocked.write(w)
+	metrics.rejected.write(w)
+	metrics.hooks.write(w)
+	metrics.mirror.write(w)
+	metrics.duration.write(w)
+
+	// rate limiters
+	writeHeader(w, "s83d_rate_limited_total", "Requests rejected by a rate limit.", "counter")
+	fmt.Fprintf(w, "s83d_rate_limited_total{limit=\"ip\"} %d\n", srv.limits.ip.state().Limited)
+	fmt.Fprintf(w, "s83d_rate_limited_total{limit=\"key\"} %d\n", srv.limits.key.state().Limited)
+
+	// store
+	writeGauge(w, "s83d_store_boards", "Boards in the store.", float64(srv.store.Count()))
+	writeGauge(w, "s83d_store_bytes", "Bytes of board content in the store.", float64(srv.store.Size()))
+	writeGauge(w, "s83d_store_blocked_keys", "Keys on the store block list.", float64(len(srv.store.BlockList())))
+
+	st := srv.store.Stats()
+	writeHeader(w, "s83d_store_operations_total", "Store operations by type.", "counter")
+	fmt.Fprintf(w, "s83d_store_operations_total{op=\"get\"} %d\n", st.Gets)
+	fmt.Fprintf(w, "s83d_store_operations_total{op=\"add\"} %d\n", st.Adds)
+	fmt.Fprintf(w, "s83d_store_operations_total{op=\"remove\"} %d\n", st.Removes)
+	writeHeader(w, "s83d_store_cache_total", "Store cache lookups by result.", "counter")
+	fmt.Fprintf(w, "s83d_store_cache_total{result=\"hit\"} %d\n", st.CacheHits)
+	fmt.Fprintf(w, "s83d_store_cache_total{result=\"miss\"} %d\n", st.CacheMisses)
+	writeHeader(w, "s83d_store_errors_total", "Store writes/removes that failed.", "counter")
+	fmt.Fprintf(w, "s83d_store_errors_total %d\n", st.Errors)
+
+	writeGauge(w, "s83d_event_subscribers", "Clients streaming board changes.", float64(srv.events.count()))
+
+	writeGauge(w, "s83d_uptime_seconds", "Seconds since the server started.", time.Since(srv.started).Seconds())
+	return nil
+}
+
+/* exposition format helpers */
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeGauge(w io.Writer, name string, help string, v float64) {
+	writeHeader(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, escapeLabel(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
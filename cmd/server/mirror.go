@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/royragsdale/s83"
+)
+
+// upstream requests are made while a client waits for a board
+const mirrorTimeout = 5 * time.Second
+
+// at most this many keys are remembered as recently checked (any key can be
+// requested, so the oldest are forgotten first)
+const mirrorMaxChecked = 10000
+
+// mirrorPolicies are the PUT policies boards copied from the upstream must
+// also pass. The others limit publishers, and PUTs are refused anyway.
+var mirrorPolicies = map[string]bool{"clock_skew": true, "newer": true, "ttl": true, "denylist": true}
+
+// A mirror serves boards from an upstream server without accepting PUTs.
+// Boards are fetched on demand, verified and cached in the local store. A
+// cached board (or a board the upstream doesn't have) is checked again once
+// it is older than the max age. Fetches are rate limited so requests for
+// random keys can't be turned into a flood of upstream requests.
+type mirror struct {
+	upstream *url.URL
+	maxAge   time.Duration
+	client   *http.Client
+	fills    *limiter // upstream fetches (a single bucket)
+
+	mu       sync.Mutex
+	checked  map[string]time.Time     // last successful check by key
+	inflight map[string]chan struct{} // closed when a fetch finishes
+}
+
+func newMirror(upstream *url.URL, maxAge time.Duration, fillRate float64, fillBurst int) *mirror {
+	return &mirror{
+		upstream: upstream,
+		maxAge:   maxAge,
+		client:   &http.Client{Timeout: mirrorTimeout},
+		fills:    newLimiter(fillRate, fillBurst),
+		checked:  map[string]time.Time{},
+		inflight: map[string]chan struct{}{},
+	}
+}
+
+// fresh reports whether key was checked within the max age, callers must hold
+// the lock
+func (m *mirror) fresh(key string, now time.Time) bool {
+	checked, ok := m.checked[key]
+	if ok && now.Sub(checked) >= m.maxAge {
+		delete(m.checked, key)
+		return false
+	}
+	return ok
+}
+
+// markChecked remembers key was checked, forgetting expired entries (or else
+// the oldest) to stay within mirrorMaxChecked. Callers must hold the lock.
+func (m *mirror) markChecked(key string, now time.Time) {
+	if _, ok := m.checked[key]; !ok && len(m.checked) >= mirrorMaxChecked {
+		oldestKey, oldest := "", now
+		for k, checked := range m.checked {
+			if now.Sub(checked) >= m.maxAge {
+				delete(m.checked, k)
+			} else if checked.Before(oldest) {
+				oldestKey, oldest = k, checked
+			}
+		}
+		if len(m.checked) >= mirrorMaxChecked {
+			delete(m.checked, oldestKey)
+		}
+	}
+	m.checked[key] = now
+}
+
+// parseUpstream checks the upstream server URL
+func parseUpstream(upstream string) (*url.URL, error) {
+	if upstream == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("not an http(s) URL: %s", upstream)
+	}
+	return u, nil
+}
+
+// boardURL is where the upstream serves a board
+func (m *mirror) boardURL(key string) string {
+	u := *m.upstream
+	u.Path = path.Join(u.Path, key)
+	return u.String()
+}
+
+// refresh makes sure the store has the upstream's board for key, unless it
+// was checked recently. Concurrent requests for a key share one fetch. If the
+// upstream can't be reached the cached board (if any) keeps being served.
+func (srv *Server) refresh(req *http.Request, key string) {
+	m := srv.mirror
+
+	m.mu.Lock()
+	if m.fresh(key, time.Now()) {
+		m.mu.Unlock()
+		return
+	}
+	if done, ok := m.inflight[key]; ok {
+		m.mu.Unlock()
+		<-done
+		return
+	}
+	if ok, _ := m.fills.allow("", time.Now()); !ok {
+		m.mu.Unlock()
+		// serve what is cached (if anything) until the upstream can be asked
+		metrics.mirror.inc("limited")
+		return
+	}
+	done := make(chan struct{})
+	m.inflight[key] = done
+	m.mu.Unlock()
+
+	err := srv.fetchUpstream(req, key)
+
+	m.mu.Lock()
+	if err == nil {
+		m.markChecked(key, time.Now())
+	}
+	delete(m.inflight, key)
+	m.mu.Unlock()
+	close(done)
+
+	if err != nil {
+		metrics.mirror.inc("error")
+		reqLog(req).Warn("failed fetching board from upstream", "key", key, "error", err)
+	}
+}
+
+// fetchUpstream gets a board from the upstream, only if it is newer than the
+// cached board
+func (srv *Server) fetchUpstream(req *http.Request, key string) error {
+	m := srv.mirror
+	upReq, err := http.NewRequest(http.MethodGet, m.boardURL(key), nil)
+	if err != nil {
+		return err
+	}
+	upReq.Header.Set("Spring-Version", s83.SpringVersion)
+
+	cached, cacheErr := srv.store.Get(key)
+	if cacheErr == nil {
+		upReq.Header.Set("If-Modified-Since", cached.Timestamp())
+	}
+
+	res, err := m.client.Do(upReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNotModified:
+		metrics.mirror.inc("not_modified")
+		return nil
+	case http.StatusNotFound:
+		// gone upstream (e.g. expired or removed by an admin)
+		metrics.mirror.inc("not_found")
+		if cacheErr == nil {
+			return srv.store.Remove(key)
+		}
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("upstream status: %s", res.Status)
+	}
+
+	// never trust the upstream, verify the board like any other
+	board, err := s83.BoardFromHTTP(key, res.Header.Get("Spring-Signature"), res.Body)
+	if err != nil {
+		return fmt.Errorf("invalid board from upstream: %w", err)
+	}
+	if cacheErr == nil && !board.AfterBoard(cached) {
+		metrics.mirror.inc("not_modified")
+		return nil
+	}
+	var existing *s83.Board
+	if cacheErr == nil {
+		existing = &cached
+	}
+	if err := srv.admitMirrored(req, board, existing); err != nil {
+		metrics.mirror.inc("rejected")
+		return fmt.Errorf("board from upstream %w", err)
+	}
+	metrics.mirror.inc("fetched")
+	return srv.store.AddMirrored(board)
+}
+
+// admitMirrored checks a board from the upstream against this server's own
+// rules (blocked keys and mirrorPolicies), as if it had been PUT here
+func (srv *Server) admitMirrored(req *http.Request, board s83.Board, existing *s83.Board) error {
+	if srv.blocked(board.Key()) {
+		return errors.New("blocked")
+	}
+	put := &PutRequest{srv, nil, req, board, existing}
+	for _, p := range srv.admission {
+		if !mirrorPolicies[p.Name()] {
+			continue
+		}
+		if err := p.Check(put); err != nil {
+			return fmt.Errorf("rejected by policy %s: %w", p.Name(), err)
+		}
+	}
+	return nil
+}
+
+// rejectMirrorPut refuses a PUT, pointing the publisher at the upstream
+func (srv *Server) rejectMirrorPut(w http.ResponseWriter, key string) error {
+	upstream := srv.mirror.boardURL(key)
+	w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="canonical"`, upstream))
+	return newHTTPError(http.StatusMethodNotAllowed, "read-only mirror, publish to "+upstream)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/royragsdale/s83"
+	"github.com/royragsdale/s83/store"
+)
+
+// offlineAdmin manages a store directly on disk (`s83d admin <command>`), for
+// use while the server is stopped. Commands that change the store take its
+// lock, so they refuse to run alongside a server using it.
+type offlineAdmin struct {
+	dir string
+	ttl int
+	out io.Writer
+}
+
+var offlineCmds = []struct{ name, args, help string }{
+	{"list", "", "list stored boards with their timestamps and key expiry"},
+	{"show", "<key>", "show a board, its signature and status"},
+	{"remove", "<key>...", "remove boards"},
+	{"block", "[-remove] <key>...", "block keys (and remove their boards)"},
+	{"verify", "", "check the signature of every board file"},
+	{"stats", "", "summarize the store"},
+}
+
+func offlineUsage() {
+	fmt.Println("usage: s83d [flags] admin <command> [args]")
+	fmt.Println("\nManages the boards in STORE directly, e.g. while the server is stopped.")
+	fmt.Println("\ncommands:")
+	for _, cmd := range offlineCmds {
+		fmt.Printf("  %-8s %-20s %s\n", cmd.name, cmd.args, cmd.help)
+	}
+}
+
+// usageError prints the usage and returns an error for a command given the
+// wrong arguments
+func usageError(format string, args ...interface{}) error {
+	offlineUsage()
+	return fmt.Errorf(format, args...)
+}
+
+// adminCmd runs `s83d admin <command> [args]`
+func adminCmd(c *conf, args []string) error {
+	if len(args) == 0 {
+		return usageError("missing admin command")
+	}
+	a := offlineAdmin{c.str(envStore), c.int(envTTL), os.Stdout}
+	if err := c.err(); err != nil {
+		return err
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "list":
+		return a.list()
+	case "show":
+		if len(args) != 1 {
+			return usageError("show takes one key")
+		}
+		return a.show(args[0])
+	case "remove":
+		if len(args) == 0 {
+			return usageError("remove takes at least one key")
+		}
+		return a.remove(args)
+	case "block":
+		fs := flag.NewFlagSet("block", flag.ContinueOnError)
+		removeFlag := fs.Bool("remove", false, "also remove the keys' boards")
+		fs.Usage = offlineUsage
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			return usageError("block takes at least one key")
+		}
+		return a.block(fs.Args(), *removeFlag)
+	case "verify":
+		return a.verify()
+	case "stats":
+		return a.stats()
+	default:
+		return usageError("unknown admin command: %s", cmd)
+	}
+}
+
+// open loads the store without changing it
+func (a offlineAdmin) open() (*store.Store, error) {
+	return store.NewReadOnly(a.dir)
+}
+
+// openLocked locks the store and loads it to be changed
+func (a offlineAdmin) openLocked() (*store.Store, *store.DirLock, error) {
+	lock, err := store.LockDir(a.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	st, err := store.New(a.dir)
+	if err != nil {
+		lock.Unlock()
+		return nil, nil, err
+	}
+	return st, lock, nil
+}
+
+func (a offlineAdmin) expired(board s83.Board) bool {
+	return !board.After(time.Now().UTC().AddDate(0, 0, -a.ttl))
+}
+
+// status describes anything stopping a board from being served (or updated)
+func (a offlineAdmin) status(st *store.Store, board s83.Board) string {
+	status := []string{}
+	if st.Blocked(board.Key()) || board.Key() == s83.InfernalKey {
+		status = append(status, "blocked")
+	}
+	if a.expired(board) {
+		status = append(status, "expired")
+	}
+	if !board.Publisher.Valid() {
+		status = append(status, "invalid key")
+	}
+	if _, unlisted := s83.ParseSpringData(board.Content)[unlistedAttr]; unlisted {
+		status = append(status, "unlisted")
+	}
+	if len(status) == 0 {
+		return "ok"
+	}
+	return strings.Join(status, ",")
+}
+
+func keyExpiry(p s83.Publisher) string {
+	expires, ok := p.Expires()
+	if !ok {
+		return "invalid"
+	}
+	return expires.Format("2006-01-02")
+}
+
+func (a offlineAdmin) list() error {
+	st, err := a.open()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tUPDATED\tKEY EXPIRES\tSTATUS")
+	for _, board := range st.Boards() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", board.Key(), board.Timestamp(), keyExpiry(board.Publisher), a.status(st, board))
+	}
+	return w.Flush()
+}
+
+func (a offlineAdmin) show(key string) error {
+	st, err := a.open()
+	if err != nil {
+		return err
+	}
+	key = strings.ToLower(key)
+	board, err := st.Get(key)
+	if err != nil {
+		return fmt.Errorf("board %s: %w", key, err)
+	}
+
+	w := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "key\t%s\n", board.Key())
+	fmt.Fprintf(w, "updated\t%s\n", board.Timestamp())
+	fmt.Fprintf(w, "expires\t%s (TTL %d days)\n", board.Time().AddDate(0, 0, a.ttl).Format(s83.TimeFormat8601), a.ttl)
+	fmt.Fprintf(w, "key expires\t%s\n", keyExpiry(board.Publisher))
+	if latest, ok := st.Latest(key); ok && latest.After(board.Time()) {
+		fmt.Fprintf(w, "latest seen\t%s\n", latest.UTC().Format(s83.TimeFormat8601))
+	}
+	fmt.Fprintf(w, "signature\t%s\n", board.Signature())
+	fmt.Fprintf(w, "verified\t%t\n", board.VerifySignature())
+	fmt.Fprintf(w, "status\t%s\n", a.status(st, board))
+	fmt.Fprintf(w, "size\t%d bytes\n", len(board.Content))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "\n%s\n", board.Content)
+	return nil
+}
+
+func (a offlineAdmin) remove(keys []string) error {
+	st, lock, err := a.openLocked()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	failed := 0
+	for _, key := range keys {
+		key = strings.ToLower(key)
+		if err := st.Remove(key); err != nil {
+			fmt.Fprintf(a.out, "failed removing %s: %v\n", key, err)
+			failed += 1
+			continue
+		}
+		fmt.Fprintf(a.out, "removed %s\n", key)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed removing %d/%d boards", failed, len(keys))
+	}
+	return nil
+}
+
+func (a offlineAdmin) block(keys []string, remove bool) error {
+	// check every key before blocking any
+	for i, key := range keys {
+		keys[i] = strings.ToLower(key)
+		if !reAdminKey.MatchString(keys[i]) {
+			return fmt.Errorf("invalid key: %s", key)
+		}
+	}
+
+	st, lock, err := a.openLocked()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	for _, key := range keys {
+		if err := st.Block(key); err != nil {
+			return err
+		}
+		fmt.Fprintf(a.out, "blocked %s\n", key)
+		if !remove {
+			continue
+		}
+		if err := st.Remove(key); err == nil {
+			fmt.Fprintf(a.out, "removed %s\n", key)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a offlineAdmin) verify() error {
+	st, err := a.open()
+	if err != nil {
+		return err
+	}
+	results, err := st.Verify()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(results))
+	for key := range results {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	invalid := 0
+	for _, key := range keys {
+		if err := results[key]; err != nil {
+			fmt.Fprintf(a.out, "INVALID %s: %v\n", key, err)
+			invalid += 1
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d/%d boards failed verification", invalid, len(results))
+	}
+	fmt.Fprintf(a.out, "verified %d boards\n", len(results))
+	return nil
+}
+
+func (a offlineAdmin) stats() error {
+	st, err := a.open()
+	if err != nil {
+		return err
+	}
+
+	boards := st.Boards()
+	expired, invalidKeys := 0, 0
+	var oldest, newest s83.Board
+	for i, board := range boards {
+		if a.expired(board) {
+			expired += 1
+		}
+		if !board.Publisher.Valid() {
+			invalidKeys += 1
+		}
+		if i == 0 || oldest.AfterBoard(board) {
+			oldest = board
+		}
+		if i == 0 || board.AfterBoard(newest) {
+			newest = board
+		}
+	}
+
+	w := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "store\t%s\n", st.Dir())
+	fmt.Fprintf(w, "boards\t%d\n", len(boards))
+	fmt.Fprintf(w, "size\t%d bytes\n", st.Size())
+	fmt.Fprintf(w, "expired\t%d (TTL %d days)\n", expired, a.ttl)
+	fmt.Fprintf(w, "invalid keys\t%d\n", invalidKeys)
+	fmt.Fprintf(w, "blocked keys\t%d\n", len(st.BlockList()))
+	fmt.Fprintf(w, "allowed keys\t%d\n", len(st.AllowList()))
+	fmt.Fprintf(w, "last change\t%d\n", st.Seq())
+	if len(boards) > 0 {
+		fmt.Fprintf(w, "oldest\t%s %s\n", oldest.Timestamp(), oldest.Key())
+		fmt.Fprintf(w, "newest\t%s %s\n", newest.Timestamp(), newest.Key())
+	}
+	return w.Flush()
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/royragsdale/s83"
+	"github.com/royragsdale/s83/store"
+)
+
+// A PutPolicy decides whether a board published with a PUT is accepted.
+// Policies only see boards with a valid signature and run in order, the first
+// to return an error rejects the PUT. Errors made with newHTTPError carry the
+// status code and reason returned to the publisher, any other error rejects
+// the board with a 403.
+type PutPolicy interface {
+	Name() string
+	Check(put *PutRequest) error
+}
+
+// PutRequest is a verified board being published
+type PutRequest struct {
+	Server   *Server // the realm the board is published to
+	W        http.ResponseWriter
+	Req      *http.Request
+	Board    s83.Board
+	Existing *s83.Board // the stored board for the key (if any)
+}
+
+// custom policies run after the built-in policies
+var customPolicies []PutPolicy
+
+// RegisterPutPolicy adds a custom policy to every server. Call it from an
+// init function in a file added to this package, e.g.
+//
+//	func init() { RegisterPutPolicy(noShouting{}) }
+func RegisterPutPolicy(p PutPolicy) {
+	customPolicies = append(customPolicies, p)
+}
+
+// policy adapts a function to a PutPolicy
+type policy struct {
+	name  string
+	check func(put *PutRequest) error
+}
+
+func (p policy) Name() string                { return p.name }
+func (p policy) Check(put *PutRequest) error { return p.check(put) }
+
+// putPolicies builds the policies for a realm: the built-in policies in
+// order, followed by any custom policies
+func (srv *Server) putPolicies() []PutPolicy {
+	policies := []PutPolicy{}
+	if srv.validateKeys {
+		policies = append(policies, policy{"key", checkKey})
+	}
+	policies = append(policies,
+		policy{"clock_skew", checkClockSkew},
+		policy{"newer", checkNewer},
+		policy{"ttl", checkTTL},
+		policy{"rate_limit", checkRateLimit},
+	)
+	if len(srv.denyPatterns) > 0 {
+		policies = append(policies, policy{"denylist", checkDenyList})
+	}
+	if srv.publish == publishAllowlist {
+		policies = append(policies, policy{"allowlist", checkAllowList})
+	}
+	if srv.maxDailyUpdates > 0 {
+		daily := newDailyLimit(srv.maxDailyUpdates)
+		srv.store.OnChange(daily.record)
+		policies = append(policies, policy{"daily_updates", daily.check})
+	}
+	return append(policies, customPolicies...)
+}
+
+// admitBoard runs a verified board through the realm's policies
+func (srv *Server) admitBoard(put *PutRequest) error {
+	for _, p := range srv.admission {
+		err := p.Check(put)
+		if err == nil {
+			continue
+		}
+		metrics.rejected.inc(p.Name())
+		if _, ok := err.(*srvError); !ok {
+			err = newHTTPErrorLog(http.StatusForbidden, err.Error(), fmt.Errorf("PUT rejected by policy %s for key: %s", p.Name(), put.Board.Key()))
+		}
+		reqLog(put.Req).Debug("PUT rejected", "policy", p.Name(), "key", put.Board.Key(), "reason", err)
+		return err
+	}
+	return nil
+}
+
+// policyNames lists the realm's policies in order
+func (srv *Server) policyNames() string {
+	names := []string{}
+	for _, p := range srv.admission {
+		names = append(names, p.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// checkKey rejects keys that are not valid Spring '83 keys (or have expired)
+func checkKey(put *PutRequest) error {
+	if !put.Board.Publisher.Valid() {
+		return newHTTPError(http.StatusForbidden, "invalid key: must end in 83eMMYY and not be expired")
+	}
+	return nil
+}
+
+// checkClockSkew rejects boards from the future. Because a board must be
+// newer than the last, one dated far ahead would block every real update.
+func checkClockSkew(put *PutRequest) error {
+	if put.Board.After(time.Now().Add(put.Server.maxFutureSkew)) {
+		return newHTTPError(http.StatusBadRequest, fmt.Sprintf("timestamp more than %s in the future", put.Server.maxFutureSkew))
+	}
+	return nil
+}
+
+// quarantineFutureBoards moves boards dated past the allowed skew out of the
+// store when it is loaded (e.g. accepted before the skew was enforced)
+func (srv *Server) quarantineFutureBoards() error {
+	moved, err := srv.store.Quarantine(time.Now().Add(srv.maxFutureSkew))
+	for _, key := range moved {
+		logger.Warn("quarantined board dated in the future", "key", key)
+	}
+	return err
+}
+
+// checkNewer rejects boards that are not newer than the stored board, or the
+// latest board seen for the key. The latest timestamp is kept after a board
+// expires or is removed so old boards can't be replayed to roll a publisher
+// back. This rejects stale boards early; the store checks again when adding
+// the board, atomically, in case of concurrent PUTs.
+func checkNewer(put *PutRequest) error {
+	if put.Existing != nil && !put.Board.AfterBoard(*put.Existing) {
+		return newHTTPError(http.StatusConflict, "not newer than existing board")
+	}
+	if latest, ok := put.Server.store.Latest(put.Board.Key()); ok && !put.Board.After(latest) {
+		return newHTTPError(http.StatusConflict, "not newer than the latest board seen for this key")
+	}
+	return nil
+}
+
+// checkTTL rejects boards older than the TTL
+func checkTTL(put *PutRequest) error {
+	if put.Server.boardExpired(put.Board) {
+		return newHTTPError(http.StatusConflict, fmt.Sprintf("older than TTL: %d days", put.Server.ttl))
+	}
+	return nil
+}
+
+// checkRateLimit applies the per key rate limit. It is only charged for boards
+// with a valid signature so nobody else can use up a publisher's limit.
+func checkRateLimit(put *PutRequest) error {
+	return put.Server.limits.checkKey(put.W, reqInfo(put.Req).ip, put.Board.Key())
+}
+
+// checkDenyList rejects boards with content matching any of DENY_PATTERNS
+func checkDenyList(put *PutRequest) error {
+	for _, re := range put.Server.denyPatterns {
+		if re.Match(put.Board.Content) {
+			return newHTTPErrorLog(http.StatusForbidden, "content not allowed", fmt.Errorf("PUT content matched %q for key: %s", re, put.Board.Key()))
+		}
+	}
+	return nil
+}
+
+// checkAllowList only accepts allowed keys (or publishers with an invite)
+func checkAllowList(put *PutRequest) error {
+	return put.Server.admitPublisher(put.Req, put.Board.Key())
+}
+
+// loadDenyPatterns reads regular expressions (one per line, # comments)
+// matched against board content
+func loadDenyPatterns(path string) ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{}
+	if path == "" {
+		return patterns, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n+1, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// dailyLimit limits how many boards a key can publish in a day, counting the
+// boards accepted by the store
+type dailyLimit struct {
+	mu      sync.Mutex
+	max     int
+	updates map[string][]time.Time // recent updates by key (oldest first)
+}
+
+func newDailyLimit(max int) *dailyLimit {
+	return &dailyLimit{max: max, updates: map[string][]time.Time{}}
+}
+
+// recent returns the updates made by key in the last day, callers must hold
+// the lock
+func (d *dailyLimit) recent(key string, now time.Time) []time.Time {
+	updates := d.updates[key]
+	for len(updates) > 0 && now.Sub(updates[0]) >= 24*time.Hour {
+		updates = updates[1:]
+	}
+	if len(updates) == 0 {
+		delete(d.updates, key)
+	} else {
+		d.updates[key] = updates
+	}
+	return updates
+}
+
+func (d *dailyLimit) record(c store.Change) {
+	if c.Removed {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.updates[c.Key] = append(d.recent(c.Key, now), now)
+}
+
+func (d *dailyLimit) check(put *PutRequest) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	updates := d.recent(put.Board.Key(), now)
+	if len(updates) < d.max {
+		return nil
+	}
+	return tooManyRequests(put.W, updates[0].Add(24*time.Hour).Sub(now), fmt.Sprintf("more than %d updates in a day", d.max))
+}
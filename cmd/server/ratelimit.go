@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prune idle buckets at most this often
+const pruneInterval = time.Minute
+
+// bucket is a single token bucket, refilled lazily when checked
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter tracks a token bucket per identifier (e.g. IP address or key).
+// A rate of zero disables the limiter.
+type limiter struct {
+	mu        sync.Mutex
+	rate      float64 // tokens per second
+	burst     float64 // bucket size
+	buckets   map[string]*bucket
+	limited   uint64 // requests rejected
+	lastPrune time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   map[string]*bucket{},
+		lastPrune: time.Now(),
+	}
+}
+
+func (l *limiter) enabled() bool {
+	return l.rate > 0
+}
+
+// allow takes a token for id if one is available. Otherwise it reports how
+// long until the next token will be available.
+func (l *limiter) allow(id string, now time.Time) (bool, time.Duration) {
+	if !l.enabled() {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) > pruneInterval {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{l.burst, now}
+		l.buckets[id] = b
+	}
+
+	// refill based on time since last seen
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens -= 1
+		return true, 0
+	}
+
+	l.limited += 1
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// prune forgets buckets that would be full by now, callers must hold the lock
+func (l *limiter) prune(now time.Time) {
+	for id, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, id)
+		}
+	}
+	l.lastPrune = now
+}
+
+// limiterState is a snapshot of a limiter for display
+type limiterState struct {
+	Enabled bool
+	Rate    float64
+	Burst   int
+	Tracked int
+	Limited uint64
+}
+
+func (l *limiter) state() limiterState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return limiterState{l.enabled(), l.rate, int(l.burst), len(l.buckets), l.limited}
+}
+
+// rateLimits applies limits per remote address and per board key. Addresses
+// on the allow list (e.g. trusted peers) are never limited.
+type rateLimits struct {
+	ip    *limiter
+	key   *limiter
+	allow []*net.IPNet
+}
+
+func newRateLimits(ipRate float64, ipBurst int, keyRate float64, keyBurst int, allow []*net.IPNet) *rateLimits {
+	return &rateLimits{newLimiter(ipRate, ipBurst), newLimiter(keyRate, keyBurst), allow}
+}
+
+// check returns a 429 error (and sets Retry-After) if the remote address has
+// exceeded its limit
+func (rl *rateLimits) check(w http.ResponseWriter, ip net.IP) error {
+	if ip == nil || containsIP(rl.allow, ip) {
+		return nil
+	}
+	if ok, wait := rl.ip.allow(ip.String(), time.Now()); !ok {
+		return tooManyRequests(w, wait, "address rate limit exceeded")
+	}
+	return nil
+}
+
+// checkKey applies the limit for a key. It is only called for PUTs once the
+// board's signature is verified, so neither readers nor anyone else can use up
+// a publisher's limit.
+func (rl *rateLimits) checkKey(w http.ResponseWriter, ip net.IP, key string) error {
+	if ip != nil && containsIP(rl.allow, ip) {
+		return nil
+	}
+	// keys are hex, so any case is the same key
+	if ok, wait := rl.key.allow(strings.ToLower(key), time.Now()); !ok {
+		return tooManyRequests(w, wait, "key rate limit exceeded")
+	}
+	return nil
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) error {
+	// Retry-After is in whole seconds, always round up
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	return newHTTPError(http.StatusTooManyRequests, msg)
+}
+
+// parseAllowList parses a comma separated list of IP addresses and CIDRs
+func parseAllowList(list string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address: %s", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/royragsdale/s83"
+	"github.com/royragsdale/s83/store"
+)
+
+// A realm is an isolated community served from the same process, picked by
+// the Host header. Each realm has its own store (and so its own boards and
+// block list), title, TTL and admin board, configured in a config file:
+//
+//	[realm spring.example.com]
+//	store = /var/lib/s83d/example
+//	title = example spring
+//
+// Every other setting is shared, except the identity key which is kept in the
+// realm's store. Requests for any other host are served by the default realm
+// (the top level settings).
+
+// realmVars can be set per realm
+var realmVars = []string{envStore, envTitle, envTTL, envAdmin}
+
+func isRealmVar(name string) bool {
+	for _, v := range realmVars {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// realmHost normalizes a host (from config or a Host header) for matching
+func realmHost(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// realmLookup returns a realm's value for a setting, falling back to the top
+// level setting
+func (c *conf) realmLookup(r realmConf, name string) (string, string) {
+	if val, ok := r.values[name]; ok {
+		return val, fmt.Sprintf("config file %s [realm %s]", c.path, r.host)
+	}
+	return c.lookup(name)
+}
+
+// realmInvalid records a validation error for a realm setting
+func (c *conf) realmInvalid(r realmConf, name string, format string, args ...interface{}) {
+	val, source := c.realmLookup(r, name)
+	msg := fmt.Sprintf(format, args...)
+	c.errs = append(c.errs, fmt.Sprintf("%s=%q (from %s): %s", name, val, source, msg))
+}
+
+// realmSettings are the validated settings for a realm
+type realmSettings struct {
+	host      string
+	storePath string
+	title     string
+	ttl       int
+	admin     *s83.Publisher
+}
+
+// realmSettings validates every configured realm. Realms must each have
+// their own store so boards are never shared between them.
+func (c *conf) realmSettings() []realmSettings {
+	realms := []realmSettings{}
+	stores := map[string]string{}
+	if abs, err := filepath.Abs(c.str(envStore)); err == nil {
+		stores[abs] = "the default realm"
+	}
+
+	for _, r := range c.realms {
+		rs := realmSettings{host: r.host}
+		if r.host == "" {
+			c.errs = append(c.errs, fmt.Sprintf("config file %s: realm without a host", c.path))
+			continue
+		}
+
+		var ok bool
+		if rs.storePath, ok = r.values[envStore]; !ok || rs.storePath == "" {
+			c.realmInvalid(r, envStore, "every realm needs its own store")
+		} else if abs, err := filepath.Abs(rs.storePath); err != nil {
+			c.realmInvalid(r, envStore, "%v", err)
+		} else if other, shared := stores[abs]; shared {
+			c.realmInvalid(r, envStore, "already used by %s", other)
+		} else {
+			stores[abs] = "realm " + r.host
+		}
+
+		rs.title, _ = c.realmLookup(r, envTitle)
+
+		ttlStr, _ := c.realmLookup(r, envTTL)
+		ttl, err := strconv.Atoi(ttlStr)
+		if err != nil {
+			c.realmInvalid(r, envTTL, "not an integer")
+		} else if ttl < 7 || ttl > 22 {
+			c.realmInvalid(r, envTTL, "must not be less than 7 or more than 22 days")
+		}
+		rs.ttl = ttl
+
+		if adminKey, _ := c.realmLookup(r, envAdmin); adminKey != "" {
+			adminPub, err := s83.NewPublisherFromKey(adminKey)
+			if err != nil {
+				c.realmInvalid(r, envAdmin, "%v", err)
+			} else {
+				rs.admin = &adminPub
+			}
+		}
+		realms = append(realms, rs)
+	}
+	return realms
+}
+
+// addRealm serves a realm from its own store, sharing everything else (rate
+// limits, templates, timeouts) with the default realm
+func (srv *Server) addRealm(rs realmSettings) error {
+	realm := *srv
+	realm.realms = nil
+	realm.title = rs.title
+	realm.ttl = rs.ttl
+	realm.admin = rs.admin
+	realm.adminSeen = newSeenSignatures(adminMaxSkew)
+	realm.readSeen = newSeenSignatures(readMaxSkew)
+	realm.writable = &writableCheck{}
+
+	var err error
+	realm.lock, err = store.LockDir(rs.storePath)
+	if err != nil {
+		return fmt.Errorf("locking store: %w", err)
+	}
+	realm.store, err = store.New(rs.storePath)
+	if err != nil {
+		realm.lock.Unlock()
+		return err
+	}
+	if err := realm.quarantineFutureBoards(); err != nil {
+		return err
+	}
+	realm.events = newBroker()
+	realm.store.OnChange(realm.events.publish)
+	if realm.hooks != nil {
+		realm.store.OnChange(realm.hooks.enqueue)
+	}
+	if realm.mirror != nil {
+		realm.mirror = newMirror(srv.mirror.upstream, srv.mirror.maxAge, srv.mirror.fills.rate, int(srv.mirror.fills.burst))
+	}
+	realm.admission = realm.putPolicies()
+
+	// each realm has its own identity
+	realm.identity, err = loadIdentity(filepath.Join(rs.storePath, serverKeyFile))
+	if err != nil {
+		return err
+	}
+
+	srv.realms[rs.host] = &realm
+	logger.Info("loaded realm", "host", rs.host, "boards", realm.store.Count(), "path", rs.storePath)
+	return nil
+}
+
+// realm picks the realm serving a request by its Host header
+func (srv *Server) realm(req *http.Request) *Server {
+	if realm, ok := srv.realms[realmHost(req.Host)]; ok {
+		return realm
+	}
+	return srv
+}
+
+// allRealms returns the default realm followed by every configured realm
+func (srv *Server) allRealms() []*Server {
+	realms := []*Server{srv}
+	for _, realm := range srv.realms {
+		realms = append(realms, realm)
+	}
+	return realms
+}
+
+// handleRealm dispatches a request to the realm for its host
+func (srv *Server) handleRealm(w http.ResponseWriter, req *http.Request) error {
+	return srv.realm(req).handler(w, req)
+}
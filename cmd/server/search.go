@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/royragsdale/s83"
+)
+
+const searchPath = "/search"
+
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 100
+	searchMaxQuery     = 256 // bytes
+	searchExcerptLen   = 200 // bytes of board text returned with each result
+)
+
+type searchResult struct {
+	Key       string `json:"key"`
+	Timestamp string `json:"timestamp"`
+	Score     int    `json:"score"`
+	Excerpt   string `json:"excerpt"`
+}
+
+type searchResults struct {
+	Query   string         `json:"query"`
+	Results []searchResult `json:"results"`
+}
+
+// handleSearch finds listed boards containing every word of ?q= (up to
+// ?limit=<n> results), when the directory is enabled
+func (srv *Server) handleSearch(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet {
+		return newHTTPError(http.StatusMethodNotAllowed, "use GET")
+	}
+	// searching lists boards, so only when the operator lists them
+	if !srv.directoryEnabled {
+		return newHTTPError(http.StatusNotFound, "directory disabled")
+	}
+
+	query := strings.TrimSpace(req.URL.Query().Get("q"))
+	if query == "" {
+		return newHTTPError(http.StatusBadRequest, "missing query: ?q=")
+	} else if len(query) > searchMaxQuery {
+		return newHTTPError(http.StatusBadRequest, "query too long")
+	}
+	limit := searchDefaultLimit
+	if l := req.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			return newHTTPError(http.StatusBadRequest, "invalid limit")
+		}
+		if limit > searchMaxLimit {
+			limit = searchMaxLimit
+		}
+	}
+
+	results := searchResults{Query: query, Results: []searchResult{}}
+	// unlisted boards are filtered after searching, so don't limit the store
+	for _, r := range srv.store.Search(query, 0) {
+		if !srv.listed(r.Board) {
+			continue
+		}
+		results.Results = append(results.Results, searchResult{
+			Key:       r.Board.Key(),
+			Timestamp: r.Board.Time().UTC().Format(s83.TimeFormat8601),
+			Score:     r.Score,
+			Excerpt:   excerpt(s83.BoardText(r.Board.Content), searchExcerptLen),
+		})
+		if len(results.Results) == limit {
+			break
+		}
+	}
+	return writeJSON(w, results)
+}
+
+// excerpt shortens text to at most n bytes, on a word boundary
+func excerpt(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n -= 1
+	}
+	text = text[:n]
+	if i := strings.LastIndex(text, " "); i > 0 {
+		text = text[:i]
+	}
+	return text + "…"
+}
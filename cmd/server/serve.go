@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// routes builds the handler for every endpoint the server supports
+func (srv *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/favicon.ico", srv.favicon)
+
+	// probes cover every realm (and skip rate limits)
+	mux.Handle(healthPath, srvHandler(srv.handleHealth))
+	mux.Handle(readyPath, srvHandler(srv.handleReady))
+
+	// all API endpoints (for the realm matching the Host)
+	mux.Handle("/", srvHandler(srv.handleRealm))
+	return srv.logRequests(mux)
+}
+
+// httpServer configures an http.Server with the configured timeouts
+func (srv *Server) httpServer() *http.Server {
+	return &http.Server{
+		Addr:              srv.address(),
+		Handler:           srv.routes(),
+		ReadTimeout:       srv.readTimeout,
+		ReadHeaderTimeout: srv.headerTimeout,
+		WriteTimeout:      srv.writeTimeout,
+		IdleTimeout:       srv.idleTimeout,
+		MaxHeaderBytes:    srv.maxHeaderBytes,
+	}
+}
+
+// unlock releases the lock on every realm's store
+func (srv *Server) unlock() {
+	for _, realm := range srv.allRealms() {
+		if realm.lock != nil {
+			realm.lock.Unlock()
+			realm.lock = nil
+		}
+	}
+}
+
+// serve runs until the server fails or receives SIGINT/SIGTERM. On a signal
+// it stops accepting connections and waits (up to the shutdown timeout) for
+// in-flight requests to finish.
+func (srv *Server) serve() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	defer srv.unlock()
+
+	servers := []*http.Server{srv.httpServer()}
+	// end event streams so they don't hold up draining requests
+	for _, realm := range srv.allRealms() {
+		servers[0].RegisterOnShutdown(realm.events.close)
+	}
+	errs := make(chan error, 2)
+
+	if srv.tlsEnabled() {
+		certs, err := newCertReloader(srv.tlsCert, srv.tlsKey)
+		if err != nil {
+			return fmt.Errorf("loading certificate: %w", err)
+		}
+		done := make(chan struct{})
+		defer close(done)
+		go certs.watchSIGHUP(done)
+
+		httpsSrv := servers[0]
+		httpsSrv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.getCertificate,
+		}
+		go func() {
+			logger.Info("starting server", "address", srv.address(), "tls", true)
+			errs <- httpsSrv.ListenAndServeTLS("", "")
+		}()
+
+		if srv.redirectPort != 0 {
+			redirectSrv := srv.redirectServer()
+			servers = append(servers, redirectSrv)
+			go func() {
+				logger.Info("starting HTTP to HTTPS redirect", "address", redirectSrv.Addr)
+				errs <- redirectSrv.ListenAndServe()
+			}()
+		}
+	} else {
+		go func() {
+			logger.Info("starting server", "address", srv.address())
+			errs <- servers[0].ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// restore default signal handling so a second signal kills immediately
+	stop()
+	logger.Info("shutting down, draining requests", "timeout", srv.shutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), srv.shutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+	}
+
+	for range servers {
+		if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	}
+	// let queued hooks finish (sharing the shutdown timeout)
+	if srv.hooks != nil {
+		srv.hooks.close(shutdownCtx)
+	}
+	if srv.accessLog != nil {
+		srv.accessLog.close()
+	}
+	logger.Info("shutdown complete")
+	return nil
+}
@@ -2,36 +2,79 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"math/rand"
 	"net/http"
-	"net/mail"
+	"os"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/royragsdale/s83"
+	"github.com/royragsdale/s83/store"
 )
 
 // convenience for error handling
 // ref: https://go.dev/blog/error-handling-and-go
 type srvHandler func(http.ResponseWriter, *http.Request) error
 
+// statusRecorder captures the status code and size written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+}
+
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{w, http.StatusOK, 0}
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.code = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Flush allows streaming responses through the recorder
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets an http.ResponseController reach the underlying writer (e.g. to
+// extend the write deadline of a stream)
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
 // satisfy http.Handler
-func (fn srvHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// TODO: add request IP address
+func (fn srvHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	w := newStatusRecorder(rw)
+	defer func() { metrics.observe(r.Method, w.code, time.Since(start)) }()
+
 	if err := fn(w, r); err != nil {
+		l := reqLog(r).with("ip", reqInfo(r).ip, "method", r.Method, "path", r.URL.Path)
 		// intentionally thrown error (e.g. bad requests)
 		if serr, ok := err.(*srvError); ok {
 			if serr.LogError != nil {
-				log.Printf("%s: %v\n", serr.Error(), serr.LogError)
+				l.Warn(serr.Error(), "error", serr.LogError)
 			}
 			http.Error(w, serr.Error(), serr.Code)
 		} else {
 			// always log unexpected internal errors
-			log.Printf("internal error: %v", err)
+			l.Error("internal error", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	}
@@ -42,18 +85,15 @@ func (srv *Server) address() string {
 }
 
 func (srv *Server) handler(w http.ResponseWriter, req *http.Request) error {
-	// Log requests (TODO: configurable verbosity)
-	log.Printf("%s %s %s", req.RemoteAddr, req.Method, req.URL)
-
 	// Common headers
 	w.Header().Set("Spring-Version", s83.SpringVersion)
 	w.Header().Set("Content-Type", "text/html;charset=utf-8")
 
 	// Servers must add the appropriate CORS headers to all responses:
-	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, PUT, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-Modified-Since, Spring-Signature, Spring-Version")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Type, Last-Modified, Spring-Signature, Spring-Version")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-Match, If-Modified-Since, If-None-Match, If-Unmodified-Since, Last-Event-ID, Spring-Invite, Spring-Signature, Spring-Version")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Type, ETag, Last-Modified, Spring-Signature, Spring-Version")
 
 	// Servers must support preflight OPTIONS requests to all endpoints
 	if req.Method == http.MethodOptions {
@@ -68,18 +108,107 @@ func (srv *Server) handler(w http.ResponseWriter, req *http.Request) error {
 		return srv.handleHome(w, req)
 	}
 
-	// GET/PUT /<key> (boards)
+	// GET /.well-known/spring83 (signed server info)
+	if req.URL.Path == s83.WellKnownPath {
+		return srv.handleServerInfo(w, req)
+	}
+
+	// GET /metrics
+	if req.URL.Path == "/metrics" && srv.metrics {
+		return srv.handleMetrics(w, req)
+	}
+
+	// signed admin API
+	if strings.HasPrefix(req.URL.Path, adminPrefix) {
+		return srv.handleAdmin(w, req)
+	}
+
+	// GET /events?key=<key> (stream of board changes)
+	if req.URL.Path == eventsPath {
+		if err := srv.limits.check(w, reqInfo(req).ip); err != nil {
+			return err
+		}
+		if err := srv.authorizeRead(req); err != nil {
+			return err
+		}
+		return srv.handleEvents(w, req)
+	}
+
+	// GET /changes?since=<seq> (boards changed, for replication)
+	if req.URL.Path == s83.ChangesPath {
+		if err := srv.limits.check(w, reqInfo(req).ip); err != nil {
+			return err
+		}
+		if err := srv.authorizeRead(req); err != nil {
+			return err
+		}
+		return srv.handleChanges(w, req)
+	}
+
+	// GET /search?q=<words> (full-text search of listed boards)
+	if req.URL.Path == searchPath {
+		if err := srv.limits.check(w, reqInfo(req).ip); err != nil {
+			return err
+		}
+		if err := srv.authorizeRead(req); err != nil {
+			return err
+		}
+		return srv.handleSearch(w, req)
+	}
+
+	// GET /feed, /feed.json (recent updates across the server)
+	if req.URL.Path == "/feed" || req.URL.Path == "/feed.json" {
+		if req.Method != http.MethodGet {
+			return newHTTPError(http.StatusMethodNotAllowed, "use GET")
+		}
+		if err := srv.limits.check(w, reqInfo(req).ip); err != nil {
+			return err
+		}
+		if err := srv.authorizeRead(req); err != nil {
+			return err
+		}
+		return srv.handleServerFeed(w, req, strings.HasSuffix(req.URL.Path, ".json"))
+	}
+
+	// GET /<key>.atom, /<key>.json (board feeds)
+	reFeed := regexp.MustCompile(`^\/([0-9A-Fa-f]{64}?)\.(atom|json)$`)
+	if submatch := reFeed.FindStringSubmatch(req.URL.Path); submatch != nil {
+		key := submatch[1]
+		reqInfo(req).key = key
+
+		if err := srv.limits.check(w, reqInfo(req).ip); err != nil {
+			return err
+		}
+		if req.Method != http.MethodGet {
+			return newHTTPError(http.StatusMethodNotAllowed, "use GET")
+		}
+		if err := srv.authorizeRead(req); err != nil {
+			return err
+		}
+		return srv.handleBoardFeed(w, req, key, submatch[2] == "json")
+	}
+
+	// GET/HEAD/PUT /<key> (boards)
 	reKey := regexp.MustCompile(`^\/([0-9A-Fa-f]{64}?)$`)
 	submatch := reKey.FindStringSubmatch(req.URL.Path)
 	if submatch != nil && len(submatch) == 2 {
 		key := submatch[1]
+		reqInfo(req).key = key
 
-		if req.Method == http.MethodGet {
+		// PUTs are only charged to a key once their signature is verified
+		if err := srv.limits.check(w, reqInfo(req).ip); err != nil {
+			return err
+		}
+
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			if err := srv.authorizeRead(req); err != nil {
+				return err
+			}
 			return srv.handleGetBoard(w, req, key)
 		} else if req.Method == http.MethodPut {
 			return srv.handlePutBoard(w, req, key)
 		} else {
-			return newHTTPError(http.StatusMethodNotAllowed, "use GET/PUT")
+			return newHTTPError(http.StatusMethodNotAllowed, "use GET/HEAD/PUT")
 		}
 	}
 
@@ -99,16 +228,25 @@ type indexData struct {
 	AdminBoard *s83.Board
 	TestBoard  *s83.Board
 	ClientCSS  template.CSS
+	IPLimit    limiterState
+	KeyLimit   limiterState
+	Directory  *directoryPage
+	ServerKey  string
+	Nonce      string // allows the page's own scripts (see boardCSP)
 }
 
 func (srv *Server) handleHome(w http.ResponseWriter, req *http.Request) error {
+	nonce, err := setBoardCSP(w)
+	if err != nil {
+		return err
+	}
 
 	var adminBoard *s83.Board = nil
 	if srv.admin != nil {
 		if a, err := srv.store.Get(srv.admin.String()); err == nil {
 			adminBoard = &a
 		} else {
-			log.Println("error loading admin board for homepage")
+			reqLog(req).Warn("error loading admin board for homepage", "error", err)
 		}
 	}
 
@@ -116,7 +254,7 @@ func (srv *Server) handleHome(w http.ResponseWriter, req *http.Request) error {
 	if t, err := srv.testBoard(); err == nil {
 		testBoard = &t
 	} else {
-		log.Println("error loading test board for homepage")
+		reqLog(req).Warn("error loading test board for homepage", "error", err)
 	}
 
 	data := indexData{
@@ -126,6 +264,17 @@ func (srv *Server) handleHome(w http.ResponseWriter, req *http.Request) error {
 		adminBoard,
 		testBoard,
 		s83.ClientCSS,
+		srv.limits.ip.state(),
+		srv.limits.key.state(),
+		nil,
+		srv.identity.String(),
+		nonce,
+	}
+
+	// the directory would leak who is publishing to a private server
+	if srv.directoryEnabled && !srv.privateRead {
+		dp := srv.directory(pageParam(req))
+		data.Directory = &dp
 	}
 
 	return srv.templates.ExecuteTemplate(w, tIndex, data)
@@ -158,12 +307,15 @@ func (srv *Server) testBoard() (s83.Board, error) {
 
 }
 
-func (srv *Server) handleGetBoard(w http.ResponseWriter, req *http.Request, key string) error {
+// lookupBoard finds the board served for a key, returning an http error if it
+// is blocked, missing or expired
+func (srv *Server) lookupBoard(req *http.Request, key string) (s83.Board, error) {
 	var board s83.Board
 	var err error
 
 	if srv.blocked(key) {
-		return newHTTPError(http.StatusForbidden, "key blocked")
+		metrics.blocked.inc(req.Method)
+		return board, newHTTPError(http.StatusForbidden, "key blocked")
 	}
 
 	// special case
@@ -171,86 +323,177 @@ func (srv *Server) handleGetBoard(w http.ResponseWriter, req *http.Request, key
 	if key == s83.TestPublic {
 		board, err = srv.testBoard()
 		if err != nil {
-			return newHTTPErrorLog(http.StatusInternalServerError, "failed generating board", err)
+			return board, newHTTPErrorLog(http.StatusInternalServerError, "failed generating board", err)
 		}
 	} else {
+		if srv.mirror != nil {
+			srv.refresh(req, key)
+		}
 		board, err = srv.store.Get(key)
 		if err != nil {
 			// TODO: other errors (internal like)
-			return newHTTPError(http.StatusNotFound, "board not found")
+			return board, newHTTPError(http.StatusNotFound, "board not found")
 		}
 	}
 
 	// TODO: handle "tombstone" boards, "404 Not Found"
 
 	if !board.VerifySignature() {
-		return newHTTPErrorLog(http.StatusInternalServerError, "bad board", fmt.Errorf("board from store failed signature validation: %s", board.Publisher))
+		return board, newHTTPErrorLog(http.StatusInternalServerError, "bad board", fmt.Errorf("board from store failed signature validation: %s", board.Publisher))
 	}
 
 	if srv.boardExpired(board) {
-		log.Println("removing expired board", board.Publisher)
+		reqLog(req).Info("removing expired board", "key", board.Publisher)
 		srv.store.Remove(board.Key())
-		return newHTTPError(http.StatusNotFound, "board not found")
+		return board, newHTTPError(http.StatusNotFound, "board not found")
+	}
+	return board, nil
+}
+
+func (srv *Server) handleGetBoard(w http.ResponseWriter, req *http.Request, key string) error {
+	board, err := srv.lookupBoard(req, key)
+	if err != nil {
+		return err
 	}
 
-	// <date and time in UTC, RFC 5322 format> TODO: ???
-	modTimeStr := req.Header.Get("If-Modified-Since")
-	modTime, err := mail.ParseDate(modTimeStr)
-	if err == nil && !board.After(modTime) {
-		// TODO: improve logging
-		// parsed a header and board is not newer than the request. Not Modified.
-		log.Printf("304 - board (%s) not newer than request (%s)\n", board.Timestamp(), modTimeStr)
+	// the response depends on whether this is a browser or a Spring client
+	w.Header().Add("Vary", "Spring-Version, Accept")
+	wrapped := wantsWrapped(req)
+	etag := boardETag(board)
+	if wrapped {
+		etag = wrappedETag(board)
+	}
+	setValidators(w, board, etag)
+
+	// If-None-Match, If-Modified-Since, etc.
+	switch preconditions(req, &board, etag) {
+	case http.StatusNotModified:
+		reqLog(req).Debug("board not modified", "key", key, "board", board.Timestamp(),
+			"if_none_match", req.Header.Get("If-None-Match"), "if_modified_since", req.Header.Get("If-Modified-Since"))
 		w.WriteHeader(http.StatusNotModified)
 		return nil
+	case http.StatusPreconditionFailed:
+		return newHTTPError(http.StatusPreconditionFailed, "precondition failed")
 	}
 
-	// TODO: (optional) special case wrap boards from requests missing a Spring-Version header
+	// special case wrap boards from (browser) requests missing a Spring-Version header
+	if wrapped {
+		return srv.handleWrappedBoard(w, req, board)
+	}
 
 	w.Header().Set("Spring-Signature", board.Signature())
+	w.Header().Set("Content-Length", strconv.Itoa(len(board.Content)))
+	if req.Method == http.MethodHead {
+		return nil
+	}
 	// DO NOT "format" board content. It is user supplied.
 	w.Write(board.Content)
 	return nil
 }
 
+// defaultBlockList is the keys every server blocks
+func defaultBlockList() map[string]bool {
+	return map[string]bool{
+		s83.InfernalKey: true,
+	}
+}
+
+// templateFuncs are available to every template
+var templateFuncs = template.FuncMap{
+	// datetime formats a board's timestamp for a <time datetime> attribute
+	"datetime": func(b s83.Board) string { return b.Time().UTC().Format(s83.TimeFormat8601) },
+	// csp is boardCSP, for a <meta> in pages served without headers (exports)
+	"csp": boardCSP,
+}
+
+func parseTemplates() *template.Template {
+	return template.Must(template.New("").Funcs(templateFuncs).ParseFS(resources, "templates/*.tmpl"))
+}
+
 func (srv *Server) blocked(key string) bool {
 	_, blocked := srv.blockList[key]
-	return blocked
+	return blocked || srv.store.Blocked(key)
 }
 
 func (srv *Server) boardExpired(board s83.Board) bool {
 	return !board.After(time.Now().UTC().AddDate(0, 0, -srv.ttl))
 }
 
+// boardExpiry is the time after which a board is considered expired
+func (srv *Server) boardExpiry(board s83.Board) time.Time {
+	return board.Time().AddDate(0, 0, srv.ttl)
+}
+
+// sweep removes all expired boards from the store returning the count removed
+func (srv *Server) sweep() int {
+	removed := 0
+	for _, board := range srv.store.Boards() {
+		if srv.boardExpired(board) {
+			if err := srv.store.Remove(board.Key()); err == nil {
+				removed += 1
+			}
+		}
+	}
+	logger.Info("sweep removed expired boards", "removed", removed)
+	return removed
+}
+
 func (srv *Server) handlePutBoard(w http.ResponseWriter, req *http.Request, key string) error {
 
+	if srv.mirror != nil {
+		return srv.rejectMirrorPut(w, key)
+	}
+
 	if srv.blocked(key) {
+		metrics.blocked.inc(req.Method)
 		return newHTTPErrorLog(http.StatusForbidden, "key blocked", fmt.Errorf("PUT blocked for key: %s", key))
 	}
 
+	// refuse to read anything obviously too large
+	if req.ContentLength > s83.MaxBoardLen {
+		return newHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("board larger than %d bytes", s83.MaxBoardLen))
+	}
+
+	// If-Unmodified-Since (sent by clients with the new board's timestamp),
+	// If-Match and If-None-Match are checked against the current board
+	var existing *s83.Board
+	etag := ""
+	if b, err := srv.store.Get(key); err == nil {
+		existing, etag = &b, boardETag(b)
+	}
+	if preconditions(req, existing, etag) != 0 {
+		return newHTTPError(http.StatusPreconditionFailed, "precondition failed: board has been modified")
+	}
+
+	body := http.MaxBytesReader(w, req.Body, s83.MaxBoardLen+1)
+
 	// Validate Board (size, signature, timestamp)
-	board, err := s83.BoardFromHTTP(key, req.Header.Get("Spring-Signature"), req.Body)
-	if err != nil {
-		// TODO: handle 400/401/409/513
-		// 400: Board was submitted with improper meta timestamp tags.
-		// 401: Board was submitted without a valid signature.
+	board, err := s83.BoardFromHTTP(key, req.Header.Get("Spring-Signature"), body)
+	if errors.Is(err, s83.ErrTooLarge) {
 		// 413: Board is larger than 2217 bytes.
+		return newHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("board larger than %d bytes", s83.MaxBoardLen))
+	} else if errors.Is(err, s83.ErrInvalidSignature) {
+		// 401: Board was submitted without a valid signature.
+		metrics.sigFailures.inc()
+		return newHTTPErrorLog(http.StatusUnauthorized, "invalid signature", fmt.Errorf("PUT invalid signature for key: %s", key))
+	} else if err != nil {
+		// TODO: handle 400/409
+		// 400: Board was submitted with improper meta timestamp tags.
 		return newHTTPErrorLog(http.StatusBadRequest, "bad board", fmt.Errorf("PUT invalid board for key: %s : %w", key, err))
 	}
 
-	existingBoard, err := srv.store.Get(key)
-	// there was a valid existing board to compare against
-	if err == nil && !board.AfterBoard(existingBoard) {
-		return newHTTPError(http.StatusConflict, "not newer than existing board")
-	}
-
-	// reject boards older than TTL
-	if srv.boardExpired(board) {
-		return newHTTPError(http.StatusConflict, fmt.Sprintf("older than TTL: %d days", srv.ttl))
+	// policies decide if the board is accepted (e.g. TTL, rate limits)
+	put := &PutRequest{srv, w, req, board, existing}
+	if err := srv.admitBoard(put); err != nil {
+		return err
 	}
 
 	err = srv.store.Add(board)
-	if err != nil {
-		fmt.Println("error saving board", err)
+	if errors.Is(err, store.ErrNotNewer) {
+		// 409: a newer board was stored since the policies checked
+		metrics.rejected.inc("newer")
+		return newHTTPError(http.StatusConflict, err.Error())
+	} else if err != nil {
 		return newHTTPErrorLog(http.StatusInternalServerError, "", fmt.Errorf("error saving board for key: %s : %w", key, err))
 	}
 
@@ -265,17 +508,58 @@ func (srv *Server) favicon(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	// support just the default -h/--help to describe the environment variables supported
+	// -h/--help describes the settings supported
 	flag.Usage = envUsage
+	confFlag := flag.String("config", "", "path to a config file")
+	printFlag := flag.Bool("print-config", false, "print the effective configuration and exit")
+	genCertFlag := flag.Bool("gen-cert", false, "write a self-signed certificate for local testing to TLS_CERT/TLS_KEY (default cert.pem/key.pem) and exit")
+	hostsFlag := flag.String("hosts", "localhost,127.0.0.1,::1", "comma separated hosts/IPs for -gen-cert")
+	setFlags := registerConfFlags(flag.CommandLine)
 	flag.Parse()
 
-	srv := NewServerFromEnv()
+	c, err := loadConf(*confFlag, setFlags())
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	http.HandleFunc("/favicon.ico", srv.favicon)
+	if *printFlag {
+		c.print(os.Stdout)
+		return
+	}
 
-	// all API endpoints
-	http.Handle("/", srvHandler(srv.handler))
+	if *genCertFlag {
+		certPath, keyPath := c.str(envTLSCert), c.str(envTLSKey)
+		if certPath == "" {
+			certPath = "cert.pem"
+		}
+		if keyPath == "" {
+			keyPath = "key.pem"
+		}
+		if err := genCert(certPath, keyPath, *hostsFlag); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("wrote self-signed certificate to %s (key %s) for %s\n", certPath, keyPath, *hostsFlag)
+		return
+	}
+
+	switch flag.Arg(0) {
+	case "":
+	case "admin":
+		if err := adminCmd(c, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "export-site":
+		if err := exportSiteCmd(c, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	default:
+		log.Fatalf("unknown command: %s (see -h)", flag.Arg(0))
+	}
 
-	log.Printf("starting server on %s", srv.address())
-	log.Fatal(http.ListenAndServe(srv.address(), nil))
+	srv := NewServer(c)
+	if err := srv.serve(); err != nil {
+		logger.Fatal("server failed", "error", err)
+	}
 }
@@ -1,17 +1,27 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/royragsdale/s83"
+	"github.com/royragsdale/s83/store"
 )
 
 // utility functions for tests
@@ -25,6 +35,8 @@ func testServer(t *testing.T) *Server {
 	// set up a server with a clean store
 	dir := t.TempDir()
 	os.Setenv(envStore, dir)
+	// test boards are not published with mined keys
+	os.Setenv(envValidateKeys, "false")
 	return NewServerFromEnv()
 }
 
@@ -68,3 +80,1823 @@ func TestPutBoardHandler(t *testing.T) {
 // NEVER formatting board content
 
 // TODO: test board counting
+
+func TestAdminAPI(t *testing.T) {
+	srv := testServer(t)
+	admin, err := s83.NewCreatorFromKey(s83.TestPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.admin = &admin.Publisher
+
+	blockKey := dateToKey(time.Now())
+	adminRequest := func(method string, path string, signer *s83.Creator) *httptest.ResponseRecorder {
+		req := NewRequest(method, path, nil, t)
+		if signer != nil {
+			signer.SignRequest(req, nil)
+		}
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// unsigned requests are rejected
+	if rr := adminRequest("GET", "/admin/stats", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned admin request: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	// requests signed by another key are rejected
+	other, err := s83.NewCreatorFromKey(strings.Repeat("1", s83.KeyLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := adminRequest("GET", "/admin/stats", &other); rr.Code != http.StatusForbidden {
+		t.Errorf("non-admin request: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	// block a key
+	if rr := adminRequest("PUT", "/admin/blocked/"+blockKey, &admin); rr.Code != http.StatusOK {
+		t.Errorf("admin block: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	if !srv.blocked(blockKey) {
+		t.Errorf("key should be blocked after admin request")
+	}
+
+	// replaying the exact same request fails
+	req := NewRequest("DELETE", "/admin/blocked/"+blockKey, nil, t)
+	admin.SignRequest(req, nil)
+	for i, want := range []int{http.StatusOK, http.StatusUnauthorized} {
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("admin unblock attempt %d: got %v want %v", i, rr.Code, want)
+		}
+	}
+	if srv.blocked(blockKey) {
+		t.Errorf("key should be unblocked after admin request")
+	}
+
+	// keys are blocked as stored, whatever their case in the request
+	if rr := adminRequest("PUT", "/admin/blocked/"+strings.ToUpper(blockKey), &admin); rr.Code != http.StatusOK {
+		t.Errorf("admin block upper case: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	if !srv.blocked(blockKey) {
+		t.Errorf("upper case key should block the stored key")
+	}
+
+	// unknown endpoint
+	if rr := adminRequest("GET", "/admin/nope", &admin); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown admin endpoint: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	srv := testServer(t)
+	allow, err := parseAllowList("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.limits = newRateLimits(1, 2, 0, 0, allow)
+
+	get := func(addr string) *httptest.ResponseRecorder {
+		req := NewRequest("GET", "/"+s83.TestPublic, nil, t)
+		req.RemoteAddr = addr
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// burst is allowed, then limited
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rr := get("198.51.100.1:8383")
+		if rr.Code != want {
+			t.Errorf("request %d: got %v want %v", i, rr.Code, want)
+		}
+		if want == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("limited response missing Retry-After")
+		}
+	}
+
+	// other addresses have their own bucket
+	if rr := get("198.51.100.2:8383"); rr.Code != http.StatusOK {
+		t.Errorf("separate address: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// allow listed addresses are never limited
+	for _, addr := range []string{"10.1.2.3:8383", "192.0.2.1:8383"} {
+		for i := 0; i < 5; i++ {
+			if rr := get(addr); rr.Code != http.StatusOK {
+				t.Errorf("allow listed address %s: got %v want %v", addr, rr.Code, http.StatusOK)
+			}
+		}
+	}
+
+	if _, err := parseAllowList("not-an-ip"); err == nil {
+		t.Errorf("invalid allow list should error")
+	}
+
+	// the key limit is only charged for verified boards, in any case
+	srv.limits = newRateLimits(0, 0, 1, 1, nil)
+	board := newTestBoard(t, "a", "<p>limited</p>", time.Now().Add(-time.Hour))
+	for i := 0; i < 3; i++ {
+		req := NewRequest("PUT", "/"+board.Key(), strings.NewReader("<p>forged</p>"), t)
+		req.Header.Set("Spring-Signature", strings.Repeat("0", s83.SigLen))
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, req)
+		if rr.Code == http.StatusTooManyRequests {
+			t.Fatalf("forged PUT %d should not be charged to the key", i)
+		}
+	}
+	req := NewRequest("PUT", "/"+board.Key(), bytes.NewReader(board.Content), t)
+	req.Header.Set("Spring-Signature", board.Signature())
+	rr := httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("verified PUT after forgeries: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// reads are never charged to the key, so nobody can starve its readers
+	for i := 0; i < 3; i++ {
+		rr = httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, NewRequest("GET", "/"+board.Key(), nil, t))
+		if rr.Code != http.StatusOK {
+			t.Errorf("GET %d of a limited key: got %v want %v", i, rr.Code, http.StatusOK)
+		}
+	}
+
+	newer := newTestBoard(t, "a", "<p>limited again</p>", time.Now().Add(-time.Minute))
+	req = NewRequest("PUT", "/"+strings.ToUpper(newer.Key()), bytes.NewReader(newer.Content), t)
+	req.Header.Set("Spring-Signature", newer.Signature())
+	rr = httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("upper case key should share the limit: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestPutBoardTooLarge(t *testing.T) {
+	srv := testServer(t)
+	key := dateToKey(time.Now())
+	big := strings.Repeat("a", s83.MaxBoardLen+1)
+
+	// known length is rejected up front, unknown length when read
+	for _, contentLength := range []int64{int64(len(big)), -1} {
+		req := NewRequest("PUT", "/"+key, strings.NewReader(big), t)
+		req.ContentLength = contentLength
+		req.Header.Set("Spring-Signature", strings.Repeat("0", s83.SigLen))
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("oversized board (length %d): got %v want %v", contentLength, rr.Code, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
+
+// A bad signature is a 401 (per the spec), not the 400 returned before metrics
+// started counting signature failures.
+func TestPutInvalidSignature(t *testing.T) {
+	srv := testServer(t)
+	board := newTestBoard(t, "a", "<p>signed</p>", time.Now().Add(-time.Hour))
+
+	req := NewRequest("PUT", "/"+board.Key(), strings.NewReader("<p>not signed</p>"), t)
+	req.Header.Set("Spring-Signature", board.Signature())
+	rr := httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("invalid signature: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv := testServer(t)
+
+	// count only this test's traffic (the metrics are shared by every handler)
+	defer func(shared *serverMetrics) { metrics = shared }(metrics)
+	metrics = newServerMetrics()
+
+	// generate some traffic
+	for _, path := range []string{"/" + s83.TestPublic, "/" + s83.InfernalKey} {
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, NewRequest("GET", path, nil, t))
+	}
+
+	rr := httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, NewRequest("GET", "/metrics", nil, t))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("metrics: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != metricsContentType {
+		t.Errorf("metrics content type: got %s want %s", ct, metricsContentType)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		`s83d_http_requests_total{method="GET",code="200"}`,
+		`s83d_blocked_requests_total{method="GET"} `,
+		`s83d_http_request_duration_seconds_bucket{le="+Inf"}`,
+		"s83d_store_boards 0\n",
+		"s83d_signature_failures_total 0\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+
+	// metrics can be disabled
+	srv.metrics = false
+	rr = httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, NewRequest("GET", "/metrics", nil, t))
+	if rr.Code == http.StatusOK {
+		t.Errorf("disabled metrics should not be served")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	srv := testServer(t)
+	proxies, err := parseAllowList("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.trustedProxies = proxies
+
+	tests := []struct {
+		remote string
+		xff    string
+		want   string
+	}{
+		{"198.51.100.1:1234", "", "198.51.100.1"},
+		{"198.51.100.1:1234", "203.0.113.9", "198.51.100.1"}, // untrusted peer
+		{"10.0.0.1:1234", "203.0.113.9", "203.0.113.9"},
+		{"10.0.0.1:1234", "192.0.2.1, 203.0.113.9, 10.0.0.2", "203.0.113.9"},
+		{"10.0.0.1:1234", "garbage", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		req := NewRequest("GET", "/", nil, t)
+		req.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if got := srv.clientIP(req).String(); got != tt.want {
+			t.Errorf("clientIP(%s, %q): got %s want %s", tt.remote, tt.xff, got, tt.want)
+		}
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	srv := testServer(t)
+
+	var buf bytes.Buffer
+	defer func(output *logOutput) { logger.output = output }(logger.output)
+	logger.output = &logOutput{out: &buf, level: levelInfo, format: "json"}
+
+	rr := httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, NewRequest("GET", "/"+s83.InfernalKey, nil, t))
+
+	id := rr.Header().Get(requestIDHeader)
+	if id == "" {
+		t.Fatalf("response missing %s", requestIDHeader)
+	}
+
+	// the request line is JSON tagged with the same request ID
+	var line map[string]interface{}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &line); err != nil {
+		t.Fatalf("log line is not JSON: %v: %s", err, buf.String())
+	}
+	if line["request_id"] != id || line["key"] != s83.InfernalKey || line["status"] != float64(http.StatusForbidden) {
+		t.Errorf("unexpected request log line: %v", line)
+	}
+
+	// debug lines are filtered at the info level
+	buf.Reset()
+	logger.Debug("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("debug line should be filtered: %s", buf.String())
+	}
+
+	if got := string(formatLogfmt([]interface{}{"a", "b c", "n", 1})); got != `a="b c" n=1` {
+		t.Errorf("unexpected logfmt: %s", got)
+	}
+}
+
+func TestGenCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := dir+"/cert.pem", dir+"/key.pem"
+
+	if err := genCert(certPath, keyPath, "localhost,127.0.0.1"); err != nil {
+		t.Fatalf("error generating certificate: %v", err)
+	}
+
+	certs, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("generated certificate should load: %v", err)
+	}
+	if cert, _ := certs.getCertificate(nil); cert == nil {
+		t.Errorf("reloader should serve the loaded certificate")
+	}
+
+	if err := genCert(certPath, keyPath, "localhost"); err == nil {
+		t.Errorf("generating a certificate should not overwrite existing files")
+	}
+}
+
+func TestConfPrecedence(t *testing.T) {
+	file := `
+# comment
+port = 9000
+title = "from file"
+
+[rate]
+ip = 2
+ip-burst = 3
+`
+	values, _, err := parseConfFile(strings.NewReader(file), "test.conf")
+	if err != nil {
+		t.Fatalf("error parsing config: %v", err)
+	}
+	if values[envRateIPBurst] != "3" || values[envTitle] != "from file" {
+		t.Errorf("unexpected parsed values: %v", values)
+	}
+
+	c := envConf()
+	c.path = "test.conf"
+	c.file = values
+	c.flags = map[string]string{envRateIP: "4"}
+	t.Setenv(envPort, "9001")
+
+	tests := []struct {
+		name   string
+		want   string
+		source string
+	}{
+		{envTTL, "22", "default"},
+		{envTitle, "from file", "config file test.conf"},
+		{envPort, "9001", "environment"},
+		{envRateIP, "4", "flag -rate-ip"},
+	}
+	for _, tt := range tests {
+		if val, source := c.lookup(tt.name); val != tt.want || source != tt.source {
+			t.Errorf("%s: got %q (%s) want %q (%s)", tt.name, val, source, tt.want, tt.source)
+		}
+	}
+
+	// validation errors name the setting and where it came from
+	c.file[envTTL] = "nope"
+	c.int(envTTL)
+	if err := c.err(); err == nil || !strings.Contains(err.Error(), `TTL="nope" (from config file test.conf)`) {
+		t.Errorf("validation error should name the setting: %v", err)
+	}
+
+	for _, bad := range []string{"bogus = 1", "no equals sign"} {
+		if _, _, err := parseConfFile(strings.NewReader(bad), "bad.conf"); err == nil {
+			t.Errorf("invalid config line should error: %s", bad)
+		}
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	file := `
+title = "a # title"
+log_level = debug
+
+[realm two.example]
+store = /tmp/two
+ttl = 7
+`
+	values, realms, err := parseConfFile(strings.NewReader(file), "test.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := envConf()
+	c.path = "test.conf"
+	c.file = values
+	c.realms = realms
+	c.flags = map[string]string{envAccessLog: "/tmp/access log"}
+
+	var out bytes.Buffer
+	c.print(&out)
+	printed, printedRealms, err := parseConfFile(&out, "printed.conf")
+	if err != nil {
+		t.Fatalf("printed config should parse: %v\n%s", err, out.String())
+	}
+
+	// every value reads back the same, and still validates
+	for _, name := range envVars {
+		if printed[name] != c.str(name) {
+			t.Errorf("%s: printed %q want %q", name, printed[name], c.str(name))
+		}
+	}
+	if len(printedRealms) != 1 || printedRealms[0].values[envTTL] != "7" {
+		t.Errorf("printed realms: %+v", printedRealms)
+	}
+	reread := envConf()
+	reread.file = printed
+	for _, name := range []string{envPort, envTTL, envMaxHeaderBytes} {
+		reread.int(name)
+	}
+	if err := reread.err(); err != nil {
+		t.Errorf("printed config should validate: %v", err)
+	}
+
+	// one error for a value that doesn't parse
+	bad := envConf()
+	bad.file = map[string]string{envTTL: "x"}
+	t.Setenv(envTTL, "")
+	if ttl := bad.int(envTTL); bad.ok(envTTL) && (ttl < 7 || ttl > 22) {
+		t.Error("range check should be skipped after a parse error")
+	}
+	if len(bad.errs) != 1 {
+		t.Errorf("expected a single error: %v", bad.errs)
+	}
+}
+
+// newTestBoard signs content (with a timestamp) using a creator derived from seed
+func newTestBoard(t *testing.T, seed string, content string, ts time.Time) s83.Board {
+	c, err := s83.NewCreatorFromKey(strings.Repeat(seed, s83.KeyLen/len(seed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeElem := fmt.Sprintf(`<time datetime="%s"></time>`, ts.UTC().Format(s83.TimeFormat8601))
+	b, err := c.NewBoard([]byte(timeElem + content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDirectory(t *testing.T) {
+	srv := testServer(t)
+	srv.directoryPageSize = 2
+
+	now := time.Now()
+	boards := []s83.Board{
+		newTestBoard(t, "1", "<p>oldest</p>", now.Add(-3*time.Hour)),
+		newTestBoard(t, "2", "<p>newest</p>", now.Add(-1*time.Hour)),
+		newTestBoard(t, "3", "<p>middle</p>", now.Add(-2*time.Hour)),
+		newTestBoard(t, "4", "<p data-spring-unlisted>hidden</p>", now),
+	}
+	for _, b := range boards {
+		if err := srv.store.Add(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := srv.directory(1)
+	if len(first.Boards) != 2 || !first.Boards[0].Eq(boards[1]) || !first.Boards[1].Eq(boards[2]) {
+		t.Errorf("first page should be the two most recent listed boards: %v", first.Boards)
+	}
+	if first.PrevPage != 0 || first.NextPage != 2 {
+		t.Errorf("unexpected first page links: %+v", first)
+	}
+
+	second := srv.directory(2)
+	if len(second.Boards) != 1 || !second.Boards[0].Eq(boards[0]) || second.PrevPage != 1 || second.NextPage != 0 {
+		t.Errorf("unexpected second page: %+v", second)
+	}
+
+	// out of range pages are clamped
+	if srv.directory(99).Page != 2 || srv.directory(-1).Page != 1 {
+		t.Errorf("out of range pages should be clamped")
+	}
+
+	// rendered on the homepage only when enabled
+	for _, enabled := range []bool{false, true} {
+		srv.directoryEnabled = enabled
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, NewRequest("GET", "/?page=1", nil, t))
+		if shown := strings.Contains(rr.Body.String(), "Recently Updated"); shown != enabled {
+			t.Errorf("directory shown %t, enabled %t", shown, enabled)
+		}
+	}
+
+	// listed boards are untrusted, so only the page's own scripts may run
+	rr := httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, NewRequest("GET", "/", nil, t))
+	csp := rr.Header().Get("Content-Security-Policy")
+	nonce := regexp.MustCompile(`script-src 'nonce-([0-9a-f]+)'`).FindStringSubmatch(csp)
+	if nonce == nil {
+		t.Fatalf("homepage CSP: %q", csp)
+	}
+	body := rr.Body.String()
+	if strings.Contains(body, "<script>") || !strings.Contains(body, `<script nonce="`+nonce[1]+`">`) {
+		t.Errorf("homepage scripts should carry the nonce")
+	}
+	if datetime := boards[1].Time().UTC().Format(s83.TimeFormat8601); !strings.Contains(body, `<time datetime="`+datetime+`">`) {
+		t.Errorf("directory should give ISO 8601 datetimes, missing %s", datetime)
+	}
+}
+
+func TestWrappedBoard(t *testing.T) {
+	srv := testServer(t)
+	board := newTestBoard(t, "5", "<p>wrap me</p>", time.Now())
+	if err := srv.store.Add(board); err != nil {
+		t.Fatal(err)
+	}
+
+	// Spring clients get the raw signed bytes
+	req := NewRequest("GET", "/"+board.Key(), nil, t)
+	req.Header.Set("Accept", "text/html")
+	rr := httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, req)
+	if rr.Body.String() != board.String() || rr.Header().Get("Spring-Signature") != board.Signature() {
+		t.Errorf("Spring client should get the raw board: %s", rr.Body)
+	}
+
+	// browsers get a page wrapping the board
+	req = NewRequest("GET", "/"+board.Key(), nil, t)
+	req.Header.Del("Spring-Version")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	req.Host = "example.com"
+	rr = httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, req)
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || rr.Header().Get("Spring-Signature") != "" {
+		t.Errorf("wrapped board: unexpected response %v %v", rr.Code, rr.Header())
+	}
+	for _, want := range []string{"attachShadow", "<td>verified</td>", "http://example.com/" + board.Key(), board.Timestamp()} {
+		if !strings.Contains(body, want) {
+			t.Errorf("wrapped board missing %q", want)
+		}
+	}
+
+	// only the page's own script may run, not any in the board
+	csp := rr.Header().Get("Content-Security-Policy")
+	nonce := regexp.MustCompile(`script-src 'nonce-([0-9a-f]+)'`).FindStringSubmatch(csp)
+	if nonce == nil || !strings.Contains(csp, "default-src 'none'") {
+		t.Fatalf("wrapped board CSP: %q", csp)
+	}
+	if !strings.Contains(body, `<script nonce="`+nonce[1]+`">`) {
+		t.Errorf("wrapped board script should carry the nonce")
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	srv := testServer(t)
+	now := time.Now()
+	listed := newTestBoard(t, "6", "<p>in the feed</p>", now.Add(-time.Hour))
+	unlisted := newTestBoard(t, "7", "<p data-spring-unlisted>not in the feed</p>", now)
+	for _, b := range []s83.Board{listed, unlisted} {
+		if err := srv.store.Add(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, NewRequest("GET", path, nil, t))
+		return rr
+	}
+
+	// per board feeds
+	for path, contentType := range map[string]string{".atom": atomContentType, ".json": jsonContentType} {
+		rr := get("/" + listed.Key() + path)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != contentType {
+			t.Errorf("%s: unexpected response %v %v", path, rr.Code, rr.Header())
+		}
+		if !strings.Contains(rr.Body.String(), listed.Signature()) {
+			t.Errorf("%s: feed should include the signature", path)
+		}
+	}
+	if rr := get("/" + strings.Repeat("0", 64) + ".atom"); rr.Code != http.StatusNotFound {
+		t.Errorf("feed for a missing board should 404: %v", rr.Code)
+	}
+
+	// the server feed lists boards, so only with the directory enabled
+	for _, path := range []string{"/feed", "/feed.json"} {
+		if rr := get(path); rr.Code != http.StatusNotFound {
+			t.Errorf("%s with the directory disabled: got %v want %v", path, rr.Code, http.StatusNotFound)
+		}
+	}
+	srv.directoryEnabled = true
+
+	// server feed omits unlisted boards
+	for _, path := range []string{"/feed", "/feed.json"} {
+		body := get(path).Body.String()
+		if !strings.Contains(body, listed.Signature()) || strings.Contains(body, unlisted.Signature()) {
+			t.Errorf("%s: should only include listed boards: %s", path, body)
+		}
+	}
+
+	// server feeds are rate limited like any read
+	for _, path := range []string{"/feed", "/feed.json"} {
+		srv.limits = newRateLimits(1, 1, 0, 0, nil)
+		for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			req := NewRequest("GET", path, nil, t)
+			req.RemoteAddr = "198.51.100.1:8383"
+			rr := httptest.NewRecorder()
+			srvHandler(srv.handler).ServeHTTP(rr, req)
+			if rr.Code != want {
+				t.Errorf("%s request %d: got %v want %v", path, i, rr.Code, want)
+			}
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	srv := testServer(t)
+	// streams outlive the write timeout, it only limits each write
+	srv.writeTimeout = 50 * time.Millisecond
+	ts := httptest.NewUnstartedServer(srv.routes())
+	ts.Config.WriteTimeout = srv.writeTimeout
+	ts.Start()
+	defer ts.Close()
+	defer srv.events.close()
+
+	first := newTestBoard(t, "8", "<p>first</p>", time.Now().Add(-time.Hour))
+	second := newTestBoard(t, "8", "<p>second</p>", time.Now())
+	other := newTestBoard(t, "9", "<p>other</p>", time.Now())
+
+	// a key is required
+	res, err := http.Get(ts.URL + eventsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("subscribing without a key should fail: %v", res.StatusCode)
+	}
+
+	// stream reads events (skipping comments/retry) until one with data, keys
+	// are matched in any case
+	subscribe := func(ts *httptest.Server, lastID string) (*bufio.Reader, func()) {
+		req, _ := http.NewRequest("GET", ts.URL+eventsPath+"?key="+strings.ToUpper(first.Key()), nil)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("unexpected content type: %s", ct)
+		}
+		return bufio.NewReader(res.Body), func() { res.Body.Close() }
+	}
+	next := func(r *bufio.Reader) string {
+		event := ""
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("stream ended: %v", err)
+			}
+			if line == "\n" && strings.Contains(event, "data:") {
+				return event
+			}
+			event += line
+		}
+	}
+
+	stream, done := subscribe(ts, "")
+	defer done()
+	// wait for the subscription to be registered before changing the store
+	for srv.events.count() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(3 * srv.writeTimeout)
+	srv.store.Add(other)
+	srv.store.Add(first)
+
+	event := next(stream)
+	if !strings.Contains(event, "id: 2\nevent: board\n") || !strings.Contains(event, first.Signature()) {
+		t.Errorf("expected only the subscribed board: %q", event)
+	}
+
+	// reconnecting clients get the events they missed
+	srv.store.Add(second)
+	resumed, doneResumed := subscribe(ts, "2")
+	defer doneResumed()
+	if event := next(resumed); !strings.Contains(event, "id: 3\n") || !strings.Contains(event, second.Signature()) {
+		t.Errorf("expected the missed event to be replayed: %q", event)
+	}
+
+	// event IDs are the store's, so they still resume after a restart
+	srv.store.Remove(first.Key())
+	srv.unlock()
+	restarted := NewServerFromEnv()
+	defer restarted.events.close()
+	ts2 := httptest.NewServer(restarted.routes())
+	defer ts2.Close()
+	afterRestart, doneRestart := subscribe(ts2, "3")
+	defer doneRestart()
+	if event := next(afterRestart); !strings.Contains(event, "id: 4\nevent: remove\n") {
+		t.Errorf("expected the removal to be replayed after a restart: %q", event)
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	srv := testServer(t)
+	board := newTestBoard(t, "a", "<p>conditional</p>", time.Now().Add(-time.Hour))
+	if err := srv.store.Add(board); err != nil {
+		t.Fatal(err)
+	}
+	etag := `"` + board.Signature() + `"`
+	before := board.Time().Add(-time.Minute).Format(http.TimeFormat)
+	after := board.Time().Add(time.Minute).Format(http.TimeFormat)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		code    int
+	}{
+		{"unconditional", "GET", nil, http.StatusOK},
+		{"head", "HEAD", nil, http.StatusOK},
+		{"if-none-match match", "GET", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"if-none-match weak", "GET", map[string]string{"If-None-Match": `"other", W/` + etag}, http.StatusNotModified},
+		{"if-none-match star", "HEAD", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"if-none-match mismatch", "GET", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"if-modified-since newer", "GET", map[string]string{"If-Modified-Since": after}, http.StatusNotModified},
+		{"if-modified-since older", "GET", map[string]string{"If-Modified-Since": before}, http.StatusOK},
+		{"if-none-match wins", "GET", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": after}, http.StatusOK},
+		{"if-match mismatch", "GET", map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed},
+		{"if-unmodified-since older", "GET", map[string]string{"If-Unmodified-Since": before}, http.StatusPreconditionFailed},
+		{"if-unmodified-since newer", "GET", map[string]string{"If-Unmodified-Since": after}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := NewRequest(tt.method, "/"+board.Key(), nil, t)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, req)
+
+		if rr.Code != tt.code {
+			t.Errorf("%s: got %d want %d", tt.name, rr.Code, tt.code)
+		}
+		if rr.Code == http.StatusPreconditionFailed {
+			continue
+		}
+		if rr.Header().Get("ETag") != etag || rr.Header().Get("Last-Modified") != board.Timestamp() {
+			t.Errorf("%s: missing validators: %v", tt.name, rr.Header())
+		}
+		wantBody := ""
+		if rr.Code == http.StatusOK && tt.method == "GET" {
+			wantBody = board.String()
+		}
+		if rr.Body.String() != wantBody {
+			t.Errorf("%s: unexpected body: %q", tt.name, rr.Body)
+		}
+		if tt.method == "HEAD" && rr.Code == http.StatusOK && rr.Header().Get("Content-Length") != strconv.Itoa(len(board.Content)) {
+			t.Errorf("%s: HEAD should report the board length: %v", tt.name, rr.Header())
+		}
+	}
+
+	// browsers get a different representation (and entity tag)
+	req := NewRequest("GET", "/"+board.Key(), nil, t)
+	req.Header.Del("Spring-Version")
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("If-None-Match", etag)
+	rr := httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Errorf("wrapped board should not match the raw entity tag: %d %v", rr.Code, rr.Header())
+	}
+}
+
+func TestConditionalPut(t *testing.T) {
+	srv := testServer(t)
+	existing := newTestBoard(t, "b", "<p>existing</p>", time.Now().Add(-time.Hour))
+	if err := srv.store.Add(existing); err != nil {
+		t.Fatal(err)
+	}
+	etag := `"` + existing.Signature() + `"`
+
+	tests := []struct {
+		name    string
+		offset  time.Duration // of the new board from the existing board
+		headers map[string]string
+		code    int
+	}{
+		{"if-unmodified-since older", time.Minute, map[string]string{"If-Unmodified-Since": existing.Time().Add(-time.Minute).Format(http.TimeFormat)}, http.StatusPreconditionFailed},
+		{"if-match mismatch", time.Minute, map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed},
+		{"if-none-match star", time.Minute, map[string]string{"If-None-Match": "*"}, http.StatusPreconditionFailed},
+		{"if-match", 2 * time.Minute, map[string]string{"If-Match": etag}, http.StatusOK},
+		// publishBoard sends the timestamp of the new board
+		{"if-unmodified-since new board", 3 * time.Minute, map[string]string{"If-Unmodified-Since": existing.Time().Add(3 * time.Minute).Format(http.TimeFormat)}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		board := newTestBoard(t, "b", "<p>"+tt.name+"</p>", existing.Time().Add(tt.offset))
+		req := NewRequest("PUT", "/"+board.Key(), bytes.NewReader(board.Content), t)
+		req.Header.Set("Spring-Signature", board.Signature())
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, req)
+		if rr.Code != tt.code {
+			t.Errorf("%s: got %d want %d: %s", tt.name, rr.Code, tt.code, rr.Body)
+		}
+		if tt.code == http.StatusOK {
+			// the following tests compare against the board just published
+			etag = `"` + board.Signature() + `"`
+			existing = board
+		}
+	}
+}
+
+func TestRealms(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(envStore, t.TempDir())
+	confPath := filepath.Join(dir, "s83d.conf")
+	file := fmt.Sprintf(`
+title = default
+
+[realm one.example]
+store = %s
+title = realm one
+
+[realm TWO.example.]
+store = %s
+ttl = 7
+`, filepath.Join(dir, "one"), filepath.Join(dir, "two"))
+	for _, path := range []string{"one", "two"} {
+		if err := os.Mkdir(filepath.Join(dir, path), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(confPath, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConf(confPath, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(c)
+	handler := srv.routes()
+
+	do := func(method string, host string, board s83.Board) *httptest.ResponseRecorder {
+		var body io.Reader
+		if method == "PUT" {
+			body = bytes.NewReader(board.Content)
+		}
+		req := NewRequest(method, "/"+board.Key(), body, t)
+		req.Header.Set("Spring-Signature", board.Signature())
+		req.Host = host
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	board := newTestBoard(t, "c", "<p>realm one only</p>", time.Now())
+	if rr := do("PUT", "one.example:8080", board); rr.Code != http.StatusOK {
+		t.Fatalf("PUT to realm failed: %d %s", rr.Code, rr.Body)
+	}
+	if rr := do("GET", "ONE.example", board); rr.Code != http.StatusOK {
+		t.Errorf("board should be served by its realm: %d", rr.Code)
+	}
+	for _, host := range []string{"two.example", "localhost"} {
+		if rr := do("GET", host, board); rr.Code != http.StatusNotFound {
+			t.Errorf("board should not be served by %s: %d", host, rr.Code)
+		}
+	}
+
+	two := srv.realm(&http.Request{Host: "two.example"})
+	if two.ttl != 7 || two.title != "default" || srv.realm(&http.Request{Host: "one.example"}).title != "realm one" {
+		t.Errorf("realm settings should override (only) their own values")
+	}
+
+	// realms can't share a store (or be missing one)
+	bad := &conf{path: "bad.conf", realms: []realmConf{
+		{"a.example", map[string]string{envStore: filepath.Join(dir, "one")}},
+		{"b.example", map[string]string{envStore: filepath.Join(dir, "one")}},
+		{"c.example", map[string]string{envTitle: "no store"}},
+	}}
+	bad.realmSettings()
+	if err := bad.err(); err == nil || !strings.Contains(err.Error(), "already used by realm a.example") || !strings.Contains(err.Error(), "needs its own store") {
+		t.Errorf("expected realm store errors: %v", err)
+	}
+	if _, _, err := parseConfFile(strings.NewReader("[realm a]\nport = 1"), "bad.conf"); err == nil {
+		t.Errorf("only realm settings should be allowed in a realm")
+	}
+}
+
+func TestServerInfo(t *testing.T) {
+	t.Setenv(envPeers, "https://peer.example")
+	srv := testServer(t)
+
+	ts := httptest.NewServer(srv.routes())
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	info, err := s83.GetServerInfo(ts.Client(), u, srv.identity.String())
+	if err != nil {
+		t.Fatalf("server info should verify: %v", err)
+	}
+	if info.Key != srv.identity.String() || info.TTL != srv.ttl || info.MaxBoardLen != s83.MaxBoardLen ||
+		len(info.Peers) != 1 || info.Policies["rate_limit_ip"] == "" {
+		t.Errorf("unexpected server info: %+v", info)
+	}
+
+	// the identity persists across restarts
+	again, err := loadIdentity(filepath.Join(os.Getenv(envStore), serverKeyFile))
+	if err != nil || again.String() != srv.identity.String() {
+		t.Errorf("identity should be loaded from the store: %v", err)
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	srv := testServer(t)
+	admin, err := s83.NewCreatorFromKey(s83.TestPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.admin = &admin.Publisher
+	srv.publish = publishAllowlist
+	srv.admission = srv.putPolicies()
+
+	put := func(board s83.Board, invite string) int {
+		req := NewRequest("PUT", "/"+board.Key(), bytes.NewReader(board.Content), t)
+		req.Header.Set("Spring-Signature", board.Signature())
+		if invite != "" {
+			req.Header.Set(s83.InviteHeader, invite)
+		}
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// unknown keys can't publish
+	board := newTestBoard(t, "c", "<p>hello</p>", time.Now().Add(-time.Hour))
+	if code := put(board, ""); code != http.StatusForbidden {
+		t.Errorf("PUT from unknown key: got %d want %d", code, http.StatusForbidden)
+	}
+
+	// an invite registers the key once
+	token, err := admin.NewInvite(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := put(board, token); code != http.StatusOK {
+		t.Errorf("PUT with invite: got %d want %d", code, http.StatusOK)
+	}
+	if !srv.store.Allowed(board.Key()) {
+		t.Errorf("key should be allowed after redeeming an invite")
+	}
+	other := newTestBoard(t, "d", "<p>hello</p>", time.Now().Add(-time.Hour))
+	if code := put(other, token); code != http.StatusForbidden {
+		t.Errorf("PUT with a used invite: got %d want %d", code, http.StatusForbidden)
+	}
+
+	// keys listed on the admin board are allowed
+	adminBoard, err := admin.NewBoard([]byte(fmt.Sprintf(`<div data-spring-allow="%s"></div>`, other.Key())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.store.Add(adminBoard); err != nil {
+		t.Fatal(err)
+	}
+	if code := put(other, ""); code != http.StatusOK {
+		t.Errorf("PUT from key on the admin board: got %d want %d", code, http.StatusOK)
+	}
+
+	// keys in the allowlist file are allowed
+	path := filepath.Join(t.TempDir(), "allowlist")
+	third := newTestBoard(t, "e", "<p>hello</p>", time.Now().Add(-time.Hour))
+	if err := os.WriteFile(path, []byte("# team\n"+third.Key()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if srv.allowFile, err = loadAllowFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if code := put(third, ""); code != http.StatusOK {
+		t.Errorf("PUT from key in the allowlist file: got %d want %d", code, http.StatusOK)
+	}
+
+	// reads stay public unless private reads are enabled
+	get := func(signer *s83.Creator) int {
+		req := NewRequest("GET", "/"+board.Key(), nil, t)
+		if signer != nil {
+			signer.SignRequest(req, nil)
+		}
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := get(nil); code != http.StatusOK {
+		t.Errorf("public GET: got %d want %d", code, http.StatusOK)
+	}
+	srv.privateRead = true
+	stranger, err := s83.NewCreatorFromKey(strings.Repeat("1", s83.KeyLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		signer *s83.Creator
+		code   int
+	}{
+		{"unsigned", nil, http.StatusUnauthorized},
+		{"stranger", &stranger, http.StatusForbidden},
+		{"admin", &admin, http.StatusOK},
+	} {
+		if code := get(tt.signer); code != tt.code {
+			t.Errorf("private GET %s: got %d want %d", tt.name, code, tt.code)
+		}
+	}
+
+	// a signed read is accepted once, and only for its own query
+	srv.directoryEnabled = true
+	req := NewRequest("GET", "/feed.json?since=0", nil, t)
+	admin.SignRequest(req, nil)
+	for i, want := range []int{http.StatusOK, http.StatusUnauthorized} {
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("signed read attempt %d: got %v want %v", i, rr.Code, want)
+		}
+	}
+	req = NewRequest("GET", "/feed.json?since=0", nil, t)
+	admin.SignRequest(req, nil)
+	req.URL.RawQuery = "since=1"
+	rr := httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("signed read with another query: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+// rejects boards mentioning spam
+type testPolicy struct{}
+
+func (testPolicy) Name() string { return "test" }
+func (testPolicy) Check(put *PutRequest) error {
+	if bytes.Contains(put.Board.Content, []byte("spam")) {
+		return fmt.Errorf("no spam")
+	}
+	return nil
+}
+
+func TestPutPolicies(t *testing.T) {
+	srv := testServer(t)
+	srv.validateKeys = true
+	srv.denyPatterns = []*regexp.Regexp{regexp.MustCompile(`(?i)casino`)}
+	srv.maxDailyUpdates = 2
+	RegisterPutPolicy(testPolicy{})
+	defer func() { customPolicies = nil }()
+	srv.admission = srv.putPolicies()
+
+	if names := srv.policyNames(); names != "key,clock_skew,newer,ttl,rate_limit,denylist,daily_updates,test" {
+		t.Errorf("unexpected policies: %s", names)
+	}
+
+	put := func(board s83.Board) *httptest.ResponseRecorder {
+		req := NewRequest("PUT", "/"+board.Key(), bytes.NewReader(board.Content), t)
+		req.Header.Set("Spring-Signature", board.Signature())
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// test keys are not valid Spring '83 keys
+	now := time.Now()
+	if rr := put(newTestBoard(t, "f", "<p>hi</p>", now)); rr.Code != http.StatusForbidden {
+		t.Errorf("invalid key: got %d want %d: %s", rr.Code, http.StatusForbidden, rr.Body)
+	}
+	srv.validateKeys = false
+	srv.admission = srv.putPolicies()
+
+	newBoard := func(content string, ts time.Time) s83.Board {
+		timeElem := fmt.Sprintf(`<time datetime="%s"></time>`, ts.UTC().Format(s83.TimeFormat8601))
+		// signed directly, creators refuse to make boards from the future
+		c, err := s83.NewCreatorFromKey(strings.Repeat("f", s83.KeyLen))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := []byte(timeElem + content)
+		b, err := s83.NewBoard(c.Publisher.String(), ed25519.Sign(c.PrivateKey, data), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name  string
+		board s83.Board
+		code  int
+	}{
+		{"future", newBoard("<p>hi</p>", now.Add(time.Hour)), http.StatusBadRequest},
+		{"denylist", newBoard("<p>CASINO</p>", now.Add(-5*time.Minute)), http.StatusForbidden},
+		{"custom", newBoard("<p>spam</p>", now.Add(-4*time.Minute)), http.StatusForbidden},
+		{"first", newBoard("<p>one</p>", now.Add(-3*time.Minute)), http.StatusOK},
+		{"older", newBoard("<p>old</p>", now.Add(-4*time.Minute)), http.StatusConflict},
+		{"second", newBoard("<p>two</p>", now.Add(-2*time.Minute)), http.StatusOK},
+		{"daily limit", newBoard("<p>three</p>", now.Add(-1*time.Minute)), http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		if rr := put(tt.board); rr.Code != tt.code {
+			t.Errorf("%s: got %d want %d: %s", tt.name, rr.Code, tt.code, rr.Body)
+		}
+	}
+}
+
+// A newer board stored while a PUT is checked (a concurrent PUT) wins: the
+// store rejects the older board when adding it.
+func TestPutRace(t *testing.T) {
+	srv := testServer(t)
+	older := newTestBoard(t, "a", "<p>older</p>", time.Now().Add(-2*time.Minute))
+	newer := newTestBoard(t, "a", "<p>newer</p>", time.Now().Add(-time.Minute))
+	srv.admission = append(srv.admission, policy{"race", func(put *PutRequest) error {
+		return srv.store.Add(newer)
+	}})
+
+	req := NewRequest("PUT", "/"+older.Key(), bytes.NewReader(older.Content), t)
+	req.Header.Set("Spring-Signature", older.Signature())
+	rr := httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("older board stored after a newer one: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if b, err := srv.store.Get(newer.Key()); err != nil || b.Signature() != newer.Signature() {
+		t.Errorf("newer board should be kept: %v", err)
+	}
+}
+
+func TestFutureBoards(t *testing.T) {
+	// a board dated far in the future, accepted before skew was enforced
+	dir := t.TempDir()
+	c, err := s83.NewCreatorFromKey(strings.Repeat("a", s83.KeyLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(fmt.Sprintf(`<time datetime="%s"></time>`, time.Now().AddDate(5, 0, 0).UTC().Format(s83.TimeFormat8601)))
+	future, err := s83.NewBoard(c.Publisher.String(), ed25519.Sign(c.PrivateKey, data), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Add(future); err != nil {
+		t.Fatal(err)
+	}
+
+	// is quarantined when the server loads the store
+	t.Setenv(envStore, dir)
+	t.Setenv(envValidateKeys, "false")
+	t.Setenv(envMaxFutureSkew, "1h")
+	srv := NewServerFromEnv()
+	if _, err := srv.store.Get(future.Key()); err == nil {
+		t.Errorf("future board should have been quarantined")
+	}
+
+	// and can't be published again
+	req := NewRequest("PUT", "/"+future.Key(), bytes.NewReader(future.Content), t)
+	req.Header.Set("Spring-Signature", future.Signature())
+	rr := httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "in the future") {
+		t.Errorf("future PUT: got %d want %d: %s", rr.Code, http.StatusBadRequest, rr.Body)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	srv := testServer(t)
+	put := func(board s83.Board) int {
+		req := NewRequest("PUT", "/"+board.Key(), bytes.NewReader(board.Content), t)
+		req.Header.Set("Spring-Signature", board.Signature())
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	old := newTestBoard(t, "c", "<p>old</p>", time.Now().Add(-2*time.Hour))
+	latest := newTestBoard(t, "c", "<p>latest</p>", time.Now().Add(-time.Hour))
+	for _, b := range []s83.Board{old, latest} {
+		if code := put(b); code != http.StatusOK {
+			t.Fatalf("PUT: got %d want %d", code, http.StatusOK)
+		}
+	}
+
+	// the latest board is gone (e.g. expired), the old one can't be replayed
+	if err := srv.store.Remove(latest.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if code := put(old); code != http.StatusConflict {
+		t.Errorf("replayed PUT: got %d want %d", code, http.StatusConflict)
+	}
+	if code := put(newTestBoard(t, "c", "<p>new</p>", time.Now())); code != http.StatusOK {
+		t.Errorf("new PUT: got %d want %d", code, http.StatusOK)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	srv := testServer(t)
+
+	posted := make(chan hookEvent, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev hookEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("bad webhook body: %v", err)
+		}
+		posted <- ev
+	}))
+	defer ts.Close()
+
+	// the command writes the event it was given to a file
+	dir := t.TempDir()
+	script := filepath.Join(dir, "hook.sh")
+	out := filepath.Join(dir, "out.json")
+	if err := os.WriteFile(script, []byte("cat > \"$1\"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.hooks = newHooks("sh "+script+" "+out, ts.URL, 1, 10, 5*time.Second)
+	srv.store.OnChange(srv.hooks.enqueue)
+
+	board := newTestBoard(t, "c", "<p>hook</p>", time.Now().Add(-time.Minute))
+	req := NewRequest("PUT", "/"+board.Key(), bytes.NewReader(board.Content), t)
+	req.Header.Set("Spring-Signature", board.Signature())
+	rr := httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PUT: got %d want %d", rr.Code, http.StatusOK)
+	}
+
+	want := hookEvent{board.Key(), board.Time().UTC().Format(s83.TimeFormat8601), board.Signature()}
+	select {
+	case ev := <-posted:
+		if ev != want {
+			t.Errorf("webhook: got %+v want %+v", ev, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook was not called")
+	}
+
+	// closing waits for the command to run
+	srv.hooks.close(context.Background())
+	var ev hookEvent
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &ev); err != nil || ev != want {
+		t.Errorf("command: got %+v want %+v: %v", ev, want, err)
+	}
+}
+
+func TestHookSources(t *testing.T) {
+	h := &hooks{queue: make(chan hookEvent, 10)}
+	board := newTestBoard(t, "c", "<p>hook</p>", time.Now().Add(-time.Minute))
+
+	// only boards published to this server run hooks
+	for _, source := range []string{store.SourceMirror, store.SourceRemove, store.SourceQuarantine} {
+		h.enqueue(store.Change{Key: board.Key(), Board: board, Source: source})
+	}
+	if len(h.queue) != 0 {
+		t.Errorf("only added boards should be queued: %d queued", len(h.queue))
+	}
+	h.enqueue(store.Change{Key: board.Key(), Board: board, Source: store.SourceAdd})
+	if len(h.queue) != 1 {
+		t.Errorf("added board should be queued")
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	// the command exits, leaving a child holding its output open
+	script := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(script, []byte("sleep 5 &\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	h := &hooks{command: []string{"sh", script}, timeout: 200 * time.Millisecond}
+
+	start := time.Now()
+	if err := h.runCommand(nil); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("hook outlived its timeout: %s", elapsed)
+	}
+}
+
+func TestSlowHooks(t *testing.T) {
+	srv := testServer(t)
+	srv.hooks = newHooks("sleep 2", "", 1, 0, 100*time.Millisecond)
+	srv.store.OnChange(srv.hooks.enqueue)
+	defer srv.hooks.close(context.Background())
+
+	// a slow (and full) hook never holds up a PUT
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		board := newTestBoard(t, "c", "<p>slow</p>", time.Now().Add(time.Duration(i-5)*time.Minute))
+		req := NewRequest("PUT", "/"+board.Key(), bytes.NewReader(board.Content), t)
+		req.Header.Set("Spring-Signature", board.Signature())
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("PUT: got %d want %d", rr.Code, http.StatusOK)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("PUTs waited for hooks: %s", elapsed)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	up := testServer(t)
+	requests := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		srvHandler(up.handler).ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := testServer(t)
+	srv.mirror = newMirror(upstreamURL, time.Hour, 0, 0)
+	get := func(key string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, NewRequest("GET", "/"+key, nil, t))
+		return rr
+	}
+
+	board := newTestBoard(t, "c", "<p>mirrored</p>", time.Now().Add(-time.Hour))
+	if err := up.store.Add(board); err != nil {
+		t.Fatal(err)
+	}
+
+	// fetched, verified and cached
+	rr := get(board.Key())
+	if rr.Code != http.StatusOK || rr.Body.String() != string(board.Content) || rr.Header().Get("Spring-Signature") != board.Signature() {
+		t.Fatalf("mirrored GET: got %d %s", rr.Code, rr.Body)
+	}
+	if _, err := srv.store.Get(board.Key()); err != nil {
+		t.Errorf("mirrored board should be cached: %v", err)
+	}
+	if get(board.Key()); requests != 1 {
+		t.Errorf("fresh board should be served from the cache: %d upstream requests", requests)
+	}
+
+	// stale boards are checked again (If-Modified-Since)
+	srv.mirror.maxAge = 0
+	newer := newTestBoard(t, "c", "<p>newer</p>", time.Now().Add(-time.Minute))
+	if rr := get(board.Key()); rr.Body.String() != string(board.Content) || requests != 2 {
+		t.Errorf("unmodified board: got %s after %d upstream requests", rr.Body, requests)
+	}
+	if err := up.store.Add(newer); err != nil {
+		t.Fatal(err)
+	}
+	if rr := get(board.Key()); rr.Body.String() != string(newer.Content) {
+		t.Errorf("updated board: got %s want %s", rr.Body, newer.Content)
+	}
+
+	// unknown boards, and boards removed upstream
+	if rr := get(dateToKey(time.Now())); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown board: got %d want %d", rr.Code, http.StatusNotFound)
+	}
+	if err := up.store.Remove(board.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if rr := get(board.Key()); rr.Code != http.StatusNotFound {
+		t.Errorf("removed board: got %d want %d", rr.Code, http.StatusNotFound)
+	}
+
+	// boards from the upstream must pass this server's policies too
+	srv.denyPatterns = []*regexp.Regexp{regexp.MustCompile(`(?i)casino`)}
+	srv.admission = srv.putPolicies()
+	spam := newTestBoard(t, "d", "<p>casino</p>", time.Now().Add(-time.Minute))
+	if err := up.store.Add(spam); err != nil {
+		t.Fatal(err)
+	}
+	if rr := get(spam.Key()); rr.Code != http.StatusNotFound {
+		t.Errorf("denied board: got %d want %d", rr.Code, http.StatusNotFound)
+	}
+
+	// fetches are rate limited, beyond the limit unknown keys aren't fetched
+	srv.mirror.fills = newLimiter(1, 1)
+	requests = 0
+	for i := 0; i < 3; i++ {
+		get(strings.Repeat(strconv.Itoa(i), s83.KeyLen))
+	}
+	if requests != 1 {
+		t.Errorf("fetches should be limited: %d upstream requests", requests)
+	}
+
+	// PUTs point at the upstream
+	req := NewRequest("PUT", "/"+newer.Key(), bytes.NewReader(newer.Content), t)
+	req.Header.Set("Spring-Signature", newer.Signature())
+	rr = httptest.NewRecorder()
+	srvHandler(srv.handler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed || !strings.Contains(rr.Header().Get("Link"), upstream.URL+"/"+newer.Key()) {
+		t.Errorf("mirror PUT: got %d %q", rr.Code, rr.Header().Get("Link"))
+	}
+}
+
+func TestMirrorChecked(t *testing.T) {
+	m := newMirror(&url.URL{}, time.Minute, 0, 0)
+	now := time.Now()
+	for i := 0; i < mirrorMaxChecked; i++ {
+		m.markChecked(strconv.Itoa(i), now.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	// full, so the oldest key is forgotten
+	m.markChecked("new", now.Add(time.Second))
+	if len(m.checked) != mirrorMaxChecked || m.fresh("0", now.Add(time.Second)) || !m.fresh("new", now.Add(time.Second)) {
+		t.Errorf("oldest key should be forgotten: %d keys", len(m.checked))
+	}
+
+	// expired keys are forgotten first, and are no longer fresh
+	later := now.Add(time.Minute + 20*time.Second)
+	m.markChecked("later", later)
+	if len(m.checked) != 1 || !m.fresh("later", later) {
+		t.Errorf("expired keys should be forgotten: %d keys", len(m.checked))
+	}
+	if m.fresh("later", later.Add(time.Minute)) {
+		t.Errorf("key should expire after the max age")
+	}
+}
+
+func TestChanges(t *testing.T) {
+	srv := testServer(t)
+	ts := httptest.NewServer(srvHandler(srv.handler))
+	defer ts.Close()
+	serverURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boards := []s83.Board{}
+	for i, seed := range []string{"a", "b", "c"} {
+		board := newTestBoard(t, seed, fmt.Sprintf("<p>%d</p>", i), time.Now().Add(time.Duration(i-10)*time.Minute))
+		if err := srv.store.Add(board); err != nil {
+			t.Fatal(err)
+		}
+		boards = append(boards, board)
+	}
+
+	// paged in order
+	page, err := s83.GetChanges(ts.Client(), serverURL, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Changes) != 2 || !page.More || page.Changes[0].Key != boards[0].Key() || page.Changes[1].Key != boards[1].Key() {
+		t.Fatalf("first page: got %+v", page)
+	}
+	if page.Changes[0].Signature != boards[0].Signature() || page.Changes[0].Timestamp != boards[0].Time().UTC().Format(s83.TimeFormat8601) {
+		t.Errorf("change: got %+v", page.Changes[0])
+	}
+	page, err = s83.GetChanges(ts.Client(), serverURL, page.Next, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Changes) != 1 || page.More || page.Changes[0].Key != boards[2].Key() {
+		t.Fatalf("last page: got %+v", page)
+	}
+
+	// an update moves the key to the end, blocked keys are skipped
+	cursor := page.Next
+	update := newTestBoard(t, "a", "<p>updated</p>", time.Now().Add(-time.Minute))
+	if err := srv.store.Add(update); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.store.Block(boards[2].Key()); err != nil {
+		t.Fatal(err)
+	}
+	changes, next, err := s83.GetAllChanges(ts.Client(), serverURL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Key != boards[1].Key() || changes[1].Signature != update.Signature() {
+		t.Errorf("all changes: got %+v", changes)
+	}
+	if next <= cursor {
+		t.Errorf("cursor should advance: got %d after %d", next, cursor)
+	}
+
+	// removals are listed so replicas can drop the board
+	if err := srv.store.Remove(boards[1].Key()); err != nil {
+		t.Fatal(err)
+	}
+	changes, _, err = s83.GetAllChanges(ts.Client(), serverURL, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Key != boards[1].Key() || !changes[0].Removed || changes[0].Signature != "" {
+		t.Errorf("removal: got %+v", changes)
+	}
+
+	// invalid requests
+	for _, query := range []string{"since=-1", "limit=0", "limit=x"} {
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, NewRequest("GET", s83.ChangesPath+"?"+query, nil, t))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d want %d", query, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	srv := testServer(t)
+	search := func(query string) (int, searchResults) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		srvHandler(srv.handler).ServeHTTP(rr, NewRequest("GET", searchPath+"?"+query, nil, t))
+		results := searchResults{}
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code, results
+	}
+
+	listed := newTestBoard(t, "a", "<h1>Spring</h1><p>A garden of tulips</p>", time.Now().Add(-time.Hour))
+	unlisted := newTestBoard(t, "b", `<p data-spring-unlisted>secret tulips</p>`, time.Now().Add(-time.Hour))
+	blocked := newTestBoard(t, "c", "<p>more tulips</p>", time.Now().Add(-time.Hour))
+	for _, b := range []s83.Board{listed, unlisted, blocked} {
+		if err := srv.store.Add(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := srv.store.Block(blocked.Key()); err != nil {
+		t.Fatal(err)
+	}
+
+	// boards are only searchable when they are listed in the directory
+	if code, _ := search("q=tulips"); code != http.StatusNotFound {
+		t.Errorf("search with the directory disabled: got %d want %d", code, http.StatusNotFound)
+	}
+	srv.directoryEnabled = true
+
+	// only listed boards are found
+	code, results := search("q=Tulips")
+	if code != http.StatusOK || len(results.Results) != 1 {
+		t.Fatalf("search: got %d %+v", code, results)
+	}
+	if r := results.Results[0]; r.Key != listed.Key() || r.Score != 1 || r.Excerpt != "Spring A garden of tulips" {
+		t.Errorf("result: got %+v", r)
+	}
+	if _, results := search("q=garden+roses"); len(results.Results) != 0 {
+		t.Errorf("every word should match: got %+v", results)
+	}
+
+	for _, query := range []string{"", "q=", "q=tulips&limit=0"} {
+		if code, _ := search(query); code != http.StatusBadRequest {
+			t.Errorf("%q: got %d want %d", query, code, http.StatusBadRequest)
+		}
+	}
+
+	if got := excerpt("naïve tulips", 3); got != "na…" {
+		t.Errorf("excerpt: got %q", got)
+	}
+}
+
+func TestExportSite(t *testing.T) {
+	srv := testServer(t)
+	listed := newTestBoard(t, "a", "<p>listed</p>", time.Now().Add(-time.Hour))
+	unlisted := newTestBoard(t, "b", "<p data-spring-unlisted>unlisted</p>", time.Now().Add(-time.Hour))
+	blocked := newTestBoard(t, "c", "<p>blocked</p>", time.Now().Add(-time.Hour))
+	for _, b := range []s83.Board{listed, unlisted, blocked} {
+		if err := srv.store.Add(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := srv.store.Block(blocked.Key()); err != nil {
+		t.Fatal(err)
+	}
+	tampered := newTestBoard(t, "d", "<p>tampered</p>", time.Now().Add(-time.Hour))
+	tampered.Content = []byte("<p>tampered with</p>")
+	if err := srv.store.Add(tampered); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "site")
+	n, err := srv.exportSite(dir, "https://spring.example.com/")
+	if err != nil || n != 2 {
+		t.Fatalf("export: got %d boards, error %v", n, err)
+	}
+
+	read := func(parts ...string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(append([]string{dir}, parts...)...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// the signed files verify offline
+	for _, b := range []s83.Board{listed, unlisted} {
+		sig, err := hex.DecodeString(strings.TrimSpace(read(b.Key(), exportSig)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s83.NewBoard(b.Key(), sig, []byte(read(b.Key(), exportRaw))); err != nil {
+			t.Errorf("exported board should verify: %v", err)
+		}
+		page := read(b.Key(), exportPage)
+		if !strings.Contains(page, "attachShadow") || !strings.Contains(page, "https://spring.example.com/"+b.Key()) || strings.Contains(page, ".atom") {
+			t.Errorf("board page: %s", page)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, blocked.Key())); !os.IsNotExist(err) {
+		t.Errorf("blocked board should not be exported: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, tampered.Key())); !os.IsNotExist(err) {
+		t.Errorf("board failing verification should not be exported: %v", err)
+	}
+
+	// only listed boards are on the index
+	index := read(exportPage)
+	if !strings.Contains(index, `href="`+listed.Key()+`/"`) || strings.Contains(index, unlisted.Key()) || strings.Contains(index, tampered.Key()) {
+		t.Errorf("index: %s", index)
+	}
+
+	// pages carry their own policy, as static hosts won't send one
+	for _, page := range []string{index, read(listed.Key(), exportPage)} {
+		csp := regexp.MustCompile(`<meta http-equiv="Content-Security-Policy" content="[^"]*script-src &#39;nonce-([0-9a-f]+)&#39;">`).FindStringSubmatch(page)
+		if csp == nil || strings.Contains(page, "<script>") || !strings.Contains(page, `<script nonce="`+csp[1]+`">`) {
+			t.Errorf("exported page should carry a CSP and script nonce: %s", page)
+		}
+	}
+	if !strings.Contains(index, `<time datetime="`+listed.Time().UTC().Format(s83.TimeFormat8601)+`">`) {
+		t.Errorf("index should give ISO 8601 datetimes: %s", index)
+	}
+
+	// never mixed with other files
+	if _, err := srv.exportSite(dir, ""); err == nil {
+		t.Error("export to a non-empty directory should fail")
+	}
+}
+
+func TestExportSiteReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	st, err := store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	board := newTestBoard(t, "a", "<p>exported</p>", time.Now().Add(-time.Hour))
+	if err := st.Add(board); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the export works from the store alone, and leaves it as it was
+	t.Setenv(envStore, dir)
+	srv, err := exportServer(envConf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := srv.exportSite(filepath.Join(t.TempDir(), "site"), ""); err != nil || n != 1 {
+		t.Errorf("export: got %d boards, error %v", n, err)
+	}
+	after, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("export should not change the store: %d files before, %d after", len(before), len(after))
+	}
+	if _, err := os.Stat(filepath.Join(dir, serverKeyFile)); !os.IsNotExist(err) {
+		t.Errorf("export should not create a server key: %v", err)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	srv := testServer(t)
+	dir := os.Getenv(envStore)
+	get := func(path string) (*httptest.ResponseRecorder, readyStatus) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, NewRequest("GET", path, nil, t))
+		status := readyStatus{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+			t.Fatalf("%s: %v: %s", path, err, rr.Body)
+		}
+		return rr, status
+	}
+
+	if rr, status := get(healthPath); rr.Code != http.StatusOK || status.Status != "ok" {
+		t.Errorf("healthz: got %d %s", rr.Code, rr.Body)
+	}
+	rr, status := get(readyPath)
+	if rr.Code != http.StatusOK || status.Status != "ready" || len(status.Checks) != 3 {
+		t.Errorf("readyz: got %d %s", rr.Code, rr.Body)
+	}
+
+	// a missing store is not ready, but still alive
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	rr, status = get(readyPath)
+	if rr.Code != http.StatusServiceUnavailable || status.Status != "not ready" || status.Checks[0].OK {
+		t.Errorf("readyz without a store directory: got %d %s", rr.Code, rr.Body)
+	}
+	// the writable check is reused for a while
+	if !status.Checks[1].OK {
+		t.Errorf("readyz should reuse the writable check: %+v", status.Checks[1])
+	}
+	srv.writable.checked = time.Time{}
+	if _, status = get(readyPath); status.Checks[1].OK {
+		t.Errorf("readyz without a store directory: writable %+v", status.Checks[1])
+	}
+	// as sent with the status (not set afterwards)
+	if h := rr.Result().Header; h.Get("Cache-Control") != "no-store" || h.Get("Content-Type") != "application/json" {
+		t.Errorf("readyz without a store directory: unexpected headers %v", h)
+	}
+	if rr, _ := get(healthPath); rr.Code != http.StatusOK {
+		t.Errorf("healthz without a store directory: got %d", rr.Code)
+	}
+
+	// missing templates
+	srv.templates = nil
+	if _, status := get(readyPath); status.Checks[2].OK {
+		t.Errorf("readyz without templates: got %+v", status.Checks[2])
+	}
+}
+
+func TestOfflineAdmin(t *testing.T) {
+	dir := t.TempDir()
+	st, err := store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	board := newTestBoard(t, "a", "<p>offline</p>", time.Now().Add(-time.Hour))
+	old := newTestBoard(t, "b", "<p>old</p>", time.Now().AddDate(0, 0, -30))
+	for _, b := range []s83.Board{board, old} {
+		if err := st.Add(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	a := offlineAdmin{dir, 22, &out}
+	run := func(fn func() error) string {
+		t.Helper()
+		out.Reset()
+		if err := fn(); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	list := run(a.list)
+	if !strings.Contains(list, board.Key()+"  "+board.Timestamp()) || !strings.Contains(list, "expired") {
+		t.Errorf("list: %s", list)
+	}
+	if show := run(func() error { return a.show(board.Key()) }); !strings.Contains(show, board.Signature()) || !strings.Contains(show, "<p>offline</p>") {
+		t.Errorf("show: %s", show)
+	}
+	if stats := run(a.stats); !regexp.MustCompile(`boards\s+2\n`).MatchString(stats) {
+		t.Errorf("stats: %s", stats)
+	}
+	if verify := run(a.verify); verify != "verified 2 boards\n" {
+		t.Errorf("verify: %s", verify)
+	}
+
+	// changes are refused while a server has loaded the store
+	t.Setenv(envStore, dir)
+	srv := NewServerFromEnv()
+	if err := a.remove([]string{old.Key()}); err != store.ErrLocked {
+		t.Errorf("remove while locked: got %v want %v", err, store.ErrLocked)
+	}
+	srv.unlock()
+
+	run(func() error { return a.remove([]string{old.Key()}) })
+
+	// an invalid key blocks nothing, even the valid keys before it
+	if err := a.block([]string{board.Key(), "not-a-key"}, true); err == nil {
+		t.Error("block with an invalid key should fail")
+	}
+	st, err = store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Blocked(board.Key()) || st.Count() != 1 {
+		t.Errorf("failed block changed the store: %d boards, blocked %v", st.Count(), st.BlockList())
+	}
+
+	run(func() error { return a.block([]string{board.Key()}, true) })
+	st, err = store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Count() != 0 || !st.Blocked(board.Key()) {
+		t.Errorf("after remove and block: %d boards, blocked %v", st.Count(), st.BlockList())
+	}
+}
+
+func TestAdminCmdUsage(t *testing.T) {
+	t.Setenv(envStore, t.TempDir())
+	for _, args := range [][]string{
+		{},
+		{"show"},
+		{"show", "a", "b"},
+		{"remove"},
+		{"block"},
+		{"block", "-remove"},
+		{"block", "-bogus", "key"},
+		{"unknown"},
+	} {
+		if err := adminCmd(envConf(), args); err == nil {
+			t.Errorf("admin %v: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// self-signed development certificates are valid for this long
+const devCertValidity = 365 * 24 * time.Hour
+
+// certReloader serves a certificate that can be swapped at runtime (SIGHUP)
+type certReloader struct {
+	mu       sync.RWMutex
+	certPath string
+	keyPath  string
+	cert     *tls.Certificate
+}
+
+func newCertReloader(certPath string, keyPath string) (*certReloader, error) {
+	cr := &certReloader{certPath: certPath, keyPath: keyPath}
+	return cr, cr.reload()
+}
+
+// reload reads the certificate and key from disk. On failure the previously
+// loaded certificate remains in use.
+func (cr *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certPath, cr.keyPath)
+	if err != nil {
+		return err
+	}
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.cert = &cert
+	return nil
+}
+
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// watchSIGHUP reloads the certificate every time the process receives SIGHUP
+// until stop is closed
+func (cr *certReloader) watchSIGHUP(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			if err := cr.reload(); err != nil {
+				logger.Error("failed reloading certificate, keeping previous", "error", err)
+			} else {
+				logger.Info("reloaded certificate", "cert", cr.certPath)
+			}
+		}
+	}
+}
+
+func (srv *Server) tlsEnabled() bool {
+	return srv.tlsCert != ""
+}
+
+// redirectServer answers plain HTTP requests with a redirect to HTTPS
+func (srv *Server) redirectServer() *http.Server {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.Host)
+		if err != nil {
+			host = req.Host
+		}
+		if srv.port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(srv.port))
+		}
+		target := "https://" + host + req.URL.RequestURI()
+		http.Redirect(w, req, target, http.StatusMovedPermanently)
+	})
+
+	// probes are answered directly so they work on either port
+	mux := http.NewServeMux()
+	mux.Handle("/", redirect)
+	mux.Handle(healthPath, srvHandler(srv.handleHealth))
+	mux.Handle(readyPath, srvHandler(srv.handleReady))
+
+	return &http.Server{
+		Addr:              net.JoinHostPort(srv.host, strconv.Itoa(srv.redirectPort)),
+		Handler:           mux,
+		ReadTimeout:       srv.readTimeout,
+		ReadHeaderTimeout: srv.headerTimeout,
+		WriteTimeout:      srv.writeTimeout,
+		IdleTimeout:       srv.idleTimeout,
+		MaxHeaderBytes:    srv.maxHeaderBytes,
+	}
+}
+
+// genCert writes a self-signed certificate (that is also its own CA) and key
+// for local testing. It refuses to overwrite existing files.
+func genCert(certPath string, keyPath string, hosts string) error {
+	for _, path := range []string{certPath, keyPath} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("refusing to overwrite existing file: %s", path)
+		}
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"s83d development"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(devCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range strings.Split(hosts, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+
+	if err := writePEM(certPath, "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+	return writePEM(keyPath, "PRIVATE KEY", keyDER, 0600)
+}
+
+func writePEM(path string, blockType string, der []byte, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
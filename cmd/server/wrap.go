@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/royragsdale/s83"
+)
+
+const tBoard = "board.html.tmpl"
+
+// boardData renders a board page. Only boards whose signature verified are
+// rendered (the page says so).
+type boardData struct {
+	Title         string
+	Board         s83.Board
+	FollowSnippet string // omitted if empty
+	ClientCSS     template.CSS
+	Home          string // link back to the homepage
+	Feeds         bool   // link to the board's feeds
+	Raw           string // link to the signed board file (exported sites)
+	Nonce         string // allows the page's own scripts (see boardCSP)
+}
+
+// wantsWrapped reports whether a request came from a browser (rather than a
+// Spring client) and should get a full HTML page instead of the raw board
+func wantsWrapped(req *http.Request) bool {
+	if req.Header.Get("Spring-Version") != "" {
+		return false
+	}
+	return strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+// boardCSP is the Content-Security-Policy for pages showing boards. Boards are
+// untrusted HTML, so (as in the client's 'Daily Spring') only the page's own
+// scripts, carrying its nonce, may run and nothing is loaded from elsewhere.
+func boardCSP(nonce string) string {
+	return fmt.Sprintf("default-src 'none'; style-src 'self' 'unsafe-inline'; font-src 'self'; img-src data:; form-action *; script-src 'nonce-%s'", nonce)
+}
+
+// newNonce returns a random nonce for a page's scripts
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// setBoardCSP sends the policy for a page showing boards, returning the nonce
+// its scripts must carry
+func setBoardCSP(w http.ResponseWriter) (string, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return "", newHTTPErrorLog(http.StatusInternalServerError, "failed rendering page", err)
+	}
+	w.Header().Set("Content-Security-Policy", boardCSP(nonce))
+	return nonce, nil
+}
+
+// boardURL is the URL this server serves a board at
+func (srv *Server) boardURL(req *http.Request, key string) string {
+	scheme := "http"
+	if req.TLS != nil || srv.tlsEnabled() {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/%s", scheme, req.Host, key)
+}
+
+// handleWrappedBoard renders a board inside a page for browsers
+func (srv *Server) handleWrappedBoard(w http.ResponseWriter, req *http.Request, board s83.Board) error {
+	nonce, err := setBoardCSP(w)
+	if err != nil {
+		return err
+	}
+	data := boardData{
+		srv.title,
+		board,
+		// a Springfile entry is an (optional) handle followed by the URL
+		fmt.Sprintf("%s\n%s", board.Key()[:8], srv.boardURL(req, board.Key())),
+		s83.ClientCSS,
+		"/",
+		true,
+		"",
+		nonce,
+	}
+	return srv.templates.ExecuteTemplate(w, tBoard, data)
+}
@@ -0,0 +1,171 @@
+package s83
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+)
+
+// Feeds allow boards to be followed with ordinary feed readers. Each entry
+// carries the board content, its timestamp and (as extension metadata) the
+// key and signature so the board can still be verified.
+
+// namespace for Spring '83 extension elements in Atom feeds
+const FeedNamespace = "https://github.com/robinsloan/spring-83"
+
+const jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+
+// FeedItem is a board and the URL it is published at
+type FeedItem struct {
+	Board Board
+	URL   string
+}
+
+// Feed describes a collection of boards (e.g. a single key, a server, or a
+// client's follows)
+type Feed struct {
+	Title   string
+	Link    string // page the feed describes
+	FeedURL string // where the feed itself lives
+	Items   []FeedItem
+}
+
+// entryID is unique per board version (the same key will publish many boards)
+func entryID(item FeedItem) string {
+	return item.URL + "#" + item.Board.Time().UTC().Format(TimeFormat8601)
+}
+
+// updated is the time of the most recent board (or now for an empty feed)
+func (f Feed) updated() time.Time {
+	latest := time.Time{}
+	for _, item := range f.Items {
+		if item.Board.Time().After(latest) {
+			latest = item.Board.Time()
+		}
+	}
+	if latest.IsZero() {
+		return time.Now().UTC()
+	}
+	return latest
+}
+
+/* Atom (RFC 4287) */
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Updated   string      `xml:"updated"`
+	Link      atomLink    `xml:"link"`
+	Author    atomAuthor  `xml:"author"`
+	Content   atomContent `xml:"content"`
+	Key       string      `xml:"s83:key"`
+	Signature string      `xml:"s83:signature"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	S83NS   string      `xml:"xmlns:s83,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// Atom renders the feed as an Atom document
+func (f Feed) Atom() ([]byte, error) {
+	feed := atomFeed{
+		XMLNS:   "http://www.w3.org/2005/Atom",
+		S83NS:   FeedNamespace,
+		Title:   f.Title,
+		ID:      f.FeedURL,
+		Updated: f.updated().UTC().Format(time.RFC3339),
+		Links:   []atomLink{{f.FeedURL, "self"}, {f.Link, "alternate"}},
+	}
+
+	for _, item := range f.Items {
+		b := item.Board
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     b.Key(),
+			ID:        entryID(item),
+			Updated:   b.Time().UTC().Format(time.RFC3339),
+			Link:      atomLink{item.URL, "alternate"},
+			Author:    atomAuthor{b.Key()},
+			Content:   atomContent{"html", b.String()},
+			Key:       b.Key(),
+			Signature: b.Signature(),
+		})
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+/* JSON Feed (1.1) */
+
+type jsonFeedSpring struct {
+	Key       string `json:"key"`
+	Signature string `json:"signature"`
+	Timestamp string `json:"timestamp"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID           string           `json:"id"`
+	URL          string           `json:"url"`
+	Title        string           `json:"title"`
+	ContentHTML  string           `json:"content_html"`
+	DateModified string           `json:"date_modified"`
+	Authors      []jsonFeedAuthor `json:"authors"`
+	Spring       jsonFeedSpring   `json:"_spring83"`
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+// JSON renders the feed as a JSON Feed document
+func (f Feed) JSON() ([]byte, error) {
+	feed := jsonFeed{jsonFeedVersion, f.Title, f.Link, f.FeedURL, []jsonFeedItem{}}
+
+	for _, item := range f.Items {
+		b := item.Board
+		ts := b.Time().UTC().Format(TimeFormat8601)
+		feed.Items = append(feed.Items, jsonFeedItem{
+			ID:           entryID(item),
+			URL:          item.URL,
+			Title:        b.Key(),
+			ContentHTML:  b.String(),
+			DateModified: ts,
+			Authors:      []jsonFeedAuthor{{b.Key()}},
+			Spring:       jsonFeedSpring{b.Key(), b.Signature(), ts},
+		})
+	}
+
+	return json.MarshalIndent(feed, "", "  ")
+}
@@ -36,8 +36,18 @@ func (f Follow) Key() string {
 	return f.publisher.String()
 }
 
+// URL is where the followed board is published
+func (f Follow) URL() string {
+	return f.url.String()
+}
+
 func (f Follow) GetBoard(modTimeStr string) (Board, error) {
-	client := &http.Client{}
+	return f.GetBoardWithClient(&http.Client{}, modTimeStr)
+}
+
+// GetBoardWithClient is GetBoard using a specific client (e.g. one that
+// trusts additional certificate authorities).
+func (f Follow) GetBoardWithClient(client *http.Client, modTimeStr string) (Board, error) {
 	req, err := http.NewRequest("GET", f.url.String(), nil)
 	if err != nil {
 		return Board{}, err
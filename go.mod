@@ -1,5 +1,5 @@
 module github.com/royragsdale/s83
 
-go 1.18
+go 1.20
 
 require golang.org/x/net v0.0.0-20220614195744-fb05da6f9022
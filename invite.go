@@ -0,0 +1,66 @@
+package s83
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InviteHeader carries an invite token on a PUT from a publisher that is not
+// (yet) allowed to publish to an invite only server.
+const InviteHeader = "Spring-Invite"
+
+var ErrInviteExpired = errors.New("Invite expired")
+
+// Invite is a single use permission to register a key, signed (offline) by a
+// server's admin key. Tokens look like <id>.<expiry unix time>.<signature>
+type Invite struct {
+	ID      string
+	Expires time.Time
+}
+
+func inviteMessage(id string, expires int64) []byte {
+	return []byte(fmt.Sprintf("spring83-invite\n%s\n%d\n", id, expires))
+}
+
+// NewInvite creates an invite token that expires at the given time.
+func (c Creator) NewInvite(expires time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(nonce)
+	sig := ed25519.Sign(c.PrivateKey, inviteMessage(id, expires.Unix()))
+	return fmt.Sprintf("%s.%d.%s", id, expires.Unix(), hex.EncodeToString(sig)), nil
+}
+
+// ParseInvite checks an invite token was signed by admin and has not expired.
+func ParseInvite(token string, admin Publisher) (Invite, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 || len(parts[0]) != 32 {
+		return Invite{}, errors.New("Invalid invite format")
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Invite{}, errors.New("Invalid invite expiry")
+	}
+	sig, err := parseSignatureHeader(parts[2])
+	if err != nil {
+		return Invite{}, err
+	}
+
+	if !ed25519.Verify(admin.PublicKey, inviteMessage(parts[0], expires), sig) {
+		return Invite{}, ErrInvalidSignature
+	}
+
+	invite := Invite{parts[0], time.Unix(expires, 0).UTC()}
+	if time.Now().After(invite.Expires) {
+		return Invite{}, ErrInviteExpired
+	}
+	return invite, nil
+}
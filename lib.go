@@ -12,6 +12,7 @@ import (
 	"math"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/net/html"
@@ -173,12 +174,37 @@ func (p Publisher) Key() string {
 	return hex.EncodeToString(p.PublicKey)
 }
 
+// Valid reports whether the key is a valid Spring '83 key that has not
+// expired.
+func (p Publisher) Valid() bool {
+	return p.valid()
+}
+
 func (p Publisher) valid() bool {
+	keyDate, ok := p.keyDate()
+	if !ok {
+		return false
+	}
+	keyExpiry := keyDate.AddDate(0, 1, 0) // valid for the entire month of expiration
+	keyStart := keyDate.AddDate(-2, 0, 0) // valid for two years preceding
+	now := time.Now().UTC()
+
+	return keyStart.Before(now) && keyExpiry.After(now)
+}
+
+// Expires returns when the key expires, it is false if the key doesn't end in a
+// valid expiry (83eMMYY).
+func (p Publisher) Expires() (time.Time, bool) {
+	keyDate, ok := p.keyDate()
+	return keyDate.AddDate(0, 1, 0), ok
+}
+
+func (p Publisher) keyDate() (time.Time, bool) {
 	// ensures a key conforms to the correct format
 	// final seven hex characters must be 83e followed by four characters, interpreted as MMYY
 	reValidKey := regexp.MustCompile(`83e(0[1-9]|1[0-2])(\d\d)$`)
 	if !reValidKey.MatchString(p.String()) {
-		return false
+		return time.Time{}, false
 	}
 
 	// the key is only valid in the two years preceding it,
@@ -186,21 +212,16 @@ func (p Publisher) valid() bool {
 	yearStr := p.String()[KeyLen-2:]
 	keyYear, err := strconv.Atoi(yearStr)
 	if err != nil {
-		return false
+		return time.Time{}, false
 	}
 
 	monthStr := p.String()[KeyLen-4 : KeyLen-2]
 	keyMonth, err := strconv.Atoi(monthStr)
 	if err != nil {
-		return false
+		return time.Time{}, false
 	}
 
-	keyDate := time.Date(yearBase+keyYear, time.Month(keyMonth), 0, 0, 0, 0, 0, time.UTC)
-	keyExpiry := keyDate.AddDate(0, 1, 0) // valid for the entire month of expiration
-	keyStart := keyDate.AddDate(-2, 0, 0) // valid for two years preceding
-	now := time.Now().UTC()
-
-	return keyStart.Before(now) && keyExpiry.After(now)
+	return time.Date(yearBase+keyYear, time.Month(keyMonth), 0, 0, 0, 0, 0, time.UTC), true
 }
 
 type Signature []byte
@@ -257,3 +278,65 @@ func ParseTimestamp(content []byte) (time.Time, error) {
 
 	// will not reach here
 }
+
+// prefix of attributes clients may scan for arbitrary board data
+const springDataPrefix = "data-spring-"
+
+// ParseSpringData collects data-spring-* attributes from anywhere in the
+// board, keyed without the prefix (e.g. data-spring-unlisted -> unlisted).
+// When an attribute appears more than once the first value seen is kept.
+func ParseSpringData(content []byte) map[string]string {
+	data := map[string]string{}
+
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		tokType := z.Next()
+		if tokType == html.ErrorToken {
+			return data
+		}
+		if tokType != html.StartTagToken && tokType != html.SelfClosingTagToken {
+			continue
+		}
+		for _, attr := range z.Token().Attr {
+			if !strings.HasPrefix(attr.Key, springDataPrefix) {
+				continue
+			}
+			key := strings.TrimPrefix(attr.Key, springDataPrefix)
+			if _, seen := data[key]; !seen {
+				data[key] = attr.Val
+			}
+		}
+	}
+}
+
+// BoardText extracts the text a reader would see in a board's content (e.g. to
+// index it for search), skipping styles and scripts. Runs of text are
+// separated by a single space.
+func BoardText(content []byte) string {
+	text := []string{}
+	hidden := 0 // depth inside elements that are not displayed
+
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.Join(text, " ")
+		case html.StartTagToken:
+			if name, _ := z.TagName(); isHiddenTag(string(name)) {
+				hidden += 1
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); isHiddenTag(string(name)) && hidden > 0 {
+				hidden -= 1
+			}
+		case html.TextToken:
+			if hidden == 0 {
+				text = append(text, strings.Fields(string(z.Text()))...)
+			}
+		}
+	}
+}
+
+func isHiddenTag(name string) bool {
+	return name == "style" || name == "script" || name == "template"
+}
@@ -1,8 +1,15 @@
 package s83
 
 import (
+	"bytes"
 	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strconv"
 	"strings"
 	"testing"
@@ -177,6 +184,227 @@ func TestStringFormats(t *testing.T) {
 
 // TODO: test strings
 // TODO: test NewBoard edge cases
-// TODO: NewBoardFromHTTP
 // TODO: parseSignatureHeader
 // TODO: test ParseTimestamp directly for edge cases (including capitalization)
+
+func TestBoardFromHTTP(t *testing.T) {
+	creator, err := NewCreatorFromKey(TestPrivate)
+	if err != nil {
+		t.Fatalf(`Error loading creator from key: %v`, err)
+	}
+
+	board, err := creator.NewBoard([]byte("foo"))
+	if err != nil {
+		t.Fatalf(`Error creating board: %v`, err)
+	}
+
+	body := io.NopCloser(bytes.NewReader(board.Content))
+	fromHTTP, err := BoardFromHTTP(board.Key(), board.Signature(), body)
+	if err != nil || !fromHTTP.Eq(board) {
+		t.Errorf("Board from HTTP should match original: %v", err)
+	}
+
+	big := io.NopCloser(strings.NewReader(strings.Repeat("a", 2*MaxBoardLen)))
+	if _, err = BoardFromHTTP(board.Key(), board.Signature(), big); err != ErrTooLarge {
+		t.Errorf("Oversized board should fail with ErrTooLarge: %v", err)
+	}
+}
+
+func TestParseSpringData(t *testing.T) {
+	content := []byte(`<p data-spring-unlisted>hi</p><img data-spring-color="red" src="x"/><b data-spring-color="blue" data-other="1"></b>`)
+	data := ParseSpringData(content)
+
+	if len(data) != 2 {
+		t.Errorf("expected 2 data attributes: %v", data)
+	}
+	if v, ok := data["unlisted"]; !ok || v != "" {
+		t.Errorf("attribute without a value should be present and empty: %v", data)
+	}
+	if data["color"] != "red" {
+		t.Errorf("first value seen should win: %v", data)
+	}
+}
+
+func TestBoardText(t *testing.T) {
+	content := []byte(`<style>p { color: red; }</style><time datetime="2022-06-16T12:00:00Z"></time><h1>Hello,
+	world</h1><script>alert("x")</script><p>Spring <b>83</b></p>`)
+	if text := BoardText(content); text != "Hello, world Spring 83" {
+		t.Errorf("got %q", text)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	creator, err := NewCreatorFromKey(TestPrivate)
+	if err != nil {
+		t.Fatalf(`Error loading creator from key: %v`, err)
+	}
+	board, err := creator.NewBoard([]byte("<p>feed & me</p>"))
+	if err != nil {
+		t.Fatalf(`Error creating board: %v`, err)
+	}
+
+	url := "https://example.com/" + board.Key()
+	feed := Feed{"test", url, url + ".atom", []FeedItem{{board, url}}}
+
+	atom, err := feed.Atom()
+	if err != nil {
+		t.Fatalf("Error rendering Atom: %v", err)
+	}
+	var parsed struct {
+		Entries []struct {
+			Updated   string `xml:"updated"`
+			Content   string `xml:"content"`
+			Signature string `xml:"https://github.com/robinsloan/spring-83 signature"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(atom, &parsed); err != nil {
+		t.Fatalf("Atom should be valid XML: %v\n%s", err, atom)
+	}
+	if len(parsed.Entries) != 1 || parsed.Entries[0].Content != board.String() ||
+		parsed.Entries[0].Signature != board.Signature() ||
+		parsed.Entries[0].Updated != board.Time().UTC().Format(time.RFC3339) {
+		t.Errorf("Atom entry should carry the board: %+v", parsed)
+	}
+
+	js, err := feed.JSON()
+	if err != nil {
+		t.Fatalf("Error rendering JSON: %v", err)
+	}
+	var jf jsonFeed
+	if err := json.Unmarshal(js, &jf); err != nil {
+		t.Fatalf("JSON feed should be valid JSON: %v", err)
+	}
+	if len(jf.Items) != 1 || jf.Items[0].ContentHTML != board.String() ||
+		jf.Items[0].Spring.Signature != board.Signature() ||
+		jf.Items[0].Spring.Timestamp != board.Time().UTC().Format(TimeFormat8601) {
+		t.Errorf("JSON feed item should carry the board: %+v", jf)
+	}
+}
+
+func TestServerInfo(t *testing.T) {
+	creator, err := NewCreatorFromKey(TestPrivate)
+	if err != nil {
+		t.Fatalf(`Error loading creator from key: %v`, err)
+	}
+
+	data, err := creator.SignServerInfo(ServerInfo{Title: "test", TTL: 22, Peers: []string{"https://example.com"}})
+	if err != nil {
+		t.Fatalf("Error signing server info: %v", err)
+	}
+	info, err := VerifyServerInfo(data, "", MaxServerInfoAge)
+	if err != nil || info.Key != TestPublic || info.Title != "test" || info.Peers[0] != "https://example.com" {
+		t.Errorf("Server info should verify: %+v %v", info, err)
+	}
+
+	// pinned to the expected key
+	if _, err := VerifyServerInfo(data, strings.ToUpper(TestPublic), MaxServerInfoAge); err != nil {
+		t.Errorf("Server info should verify against its pinned key: %v", err)
+	}
+	if _, err := VerifyServerInfo(data, InfernalKey, MaxServerInfoAge); err != ErrServerKeyMismatch {
+		t.Errorf("Server info signed by another key should fail: %v", err)
+	}
+
+	// replayed (timestamps have second precision, so it is already older)
+	if _, err := VerifyServerInfo(data, TestPublic, time.Nanosecond); err != ErrServerInfoStale {
+		t.Errorf("Stale server info should fail: %v", err)
+	}
+
+	tampered := bytes.Replace(data, []byte(`"ttl_days":22`), []byte(`"ttl_days":7`), 1)
+	if bytes.Equal(tampered, data) {
+		t.Fatalf("failed to tamper with server info: %s", data)
+	}
+	if _, err := VerifyServerInfo(tampered, "", 0); err != ErrInvalidSignature {
+		t.Errorf("Tampered server info should fail verification: %v", err)
+	}
+}
+
+func TestInvite(t *testing.T) {
+	admin, err := NewCreatorFromKey(TestPrivate)
+	if err != nil {
+		t.Fatalf(`Error loading creator from key: %v`, err)
+	}
+
+	token, err := admin.NewInvite(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Error creating invite: %v", err)
+	}
+	invite, err := ParseInvite(token, admin.Publisher)
+	if err != nil || len(invite.ID) != 32 {
+		t.Errorf("Invite should parse: %+v %v", invite, err)
+	}
+
+	// signed by someone else
+	other, err := NewCreatorFromKey(strings.Repeat("1", KeyLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseInvite(token, other.Publisher); err != ErrInvalidSignature {
+		t.Errorf("Invite from another key should fail: %v", err)
+	}
+
+	expired, err := admin.NewInvite(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Error creating invite: %v", err)
+	}
+	if _, err := ParseInvite(expired, admin.Publisher); err != ErrInviteExpired {
+		t.Errorf("Expired invite should fail: %v", err)
+	}
+
+	if _, err := ParseInvite("not.an.invite", admin.Publisher); err == nil {
+		t.Errorf("Malformed invite should fail")
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	creator, err := NewCreatorFromKey(TestPrivate)
+	if err != nil {
+		t.Fatalf(`Error loading creator from key: %v`, err)
+	}
+
+	req := httptest.NewRequest("GET", "/search?q=spring", nil)
+	creator.SignRequest(req, nil)
+	if pub, _, err := VerifyRequest(req, nil, time.Minute); err != nil || pub.String() != TestPublic {
+		t.Errorf("Signed request should verify: %s %v", pub, err)
+	}
+
+	// the query is signed too
+	req.URL.RawQuery = "q=autumn"
+	if _, _, err := VerifyRequest(req, nil, time.Minute); err != ErrInvalidSignature {
+		t.Errorf("Request with another query should fail: %v", err)
+	}
+}
+
+func TestGetAllChanges(t *testing.T) {
+	// pages of two, the second has every change left out (e.g. blocked keys)
+	pages := map[string]ChangesPage{
+		"0": {[]Change{{Seq: 1, Key: "a"}, {Seq: 2, Key: "b"}}, 2, true},
+		"2": {[]Change{}, 4, true},
+		"4": {[]Change{{Seq: 5, Key: "a", Removed: true}}, 5, false},
+	}
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		page, ok := pages[r.URL.Query().Get("since")]
+		if !ok || r.URL.Path != ChangesPath {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer ts.Close()
+	server, _ := url.Parse(ts.URL)
+
+	changes, next, err := GetAllChanges(ts.Client(), server, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 || next != 5 || requests != 3 || !changes[2].Removed {
+		t.Errorf("all changes: got %+v next %d after %d requests", changes, next, requests)
+	}
+
+	// a server that never advances the cursor is an error, not a loop
+	pages["5"] = ChangesPage{[]Change{}, 5, true}
+	if _, next, err := GetAllChanges(ts.Client(), server, 5); err == nil || next != 5 {
+		t.Errorf("stuck cursor: got %d, %v", next, err)
+	}
+}
@@ -0,0 +1,144 @@
+package s83
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// WellKnownPath is where a server publishes a signed description of itself.
+const WellKnownPath = "/.well-known/spring83"
+
+// limit on the size of a server info document read from a server
+const maxServerInfoLen = 64 * 1024
+
+// MaxServerInfoAge is how old a fetched server info document may be. Servers
+// sign it for every request, so an older document is being replayed.
+const MaxServerInfoAge = 10 * time.Minute
+
+// ErrServerKeyMismatch is returned when server info is signed by a key other
+// than the one expected (pinned) for the server
+var ErrServerKeyMismatch = errors.New("Invalid server info: unexpected server key")
+
+// ErrServerInfoStale is returned when server info was signed too long ago
+var ErrServerInfoStale = errors.New("Invalid server info: too old")
+
+// ServerInfo describes a server, its limits and its policies. It is signed by
+// the server's identity key so clients and peers can verify who they are
+// talking to.
+type ServerInfo struct {
+	Key           string            `json:"key"` // server identity
+	Title         string            `json:"title"`
+	SpringVersion string            `json:"spring_version"`
+	TTL           int               `json:"ttl_days"`
+	MaxBoardLen   int               `json:"max_board_len"`
+	AdminBoard    string            `json:"admin_board,omitempty"`
+	Peers         []string          `json:"peers"`
+	Policies      map[string]string `json:"policies"`
+	Timestamp     string            `json:"timestamp"` // when it was signed
+}
+
+// signedServerInfo is the document served: the info exactly as signed.
+type signedServerInfo struct {
+	Info      json.RawMessage `json:"info"`
+	Signature string          `json:"signature"`
+}
+
+// SignServerInfo sets the key and timestamp of info and returns the signed
+// document.
+func (c Creator) SignServerInfo(info ServerInfo) ([]byte, error) {
+	info.Key = c.Publisher.String()
+	info.Timestamp = time.Now().UTC().Format(TimeFormat8601)
+
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	sig := ed25519.Sign(c.PrivateKey, raw)
+	return json.Marshal(signedServerInfo{raw, hex.EncodeToString(sig)})
+}
+
+// VerifyServerInfo parses a signed server info document and checks it was
+// signed by the key it contains, no more than maxAge ago (if maxAge is set).
+// On its own that only shows the server holds the key it claims, so if key is
+// not empty the document must also be signed by that (pinned) key.
+func VerifyServerInfo(data []byte, key string, maxAge time.Duration) (ServerInfo, error) {
+	var signed signedServerInfo
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return ServerInfo{}, err
+	}
+
+	var info ServerInfo
+	if err := json.Unmarshal(signed.Info, &info); err != nil {
+		return ServerInfo{}, err
+	}
+
+	pub, err := NewPublisherFromKey(info.Key)
+	if err != nil {
+		return ServerInfo{}, fmt.Errorf("Invalid server key: %w", err)
+	}
+	sig, err := parseSignatureHeader(signed.Signature)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+	// the signature covers the compact encoding (in case it was reformatted)
+	var raw bytes.Buffer
+	if err := json.Compact(&raw, signed.Info); err != nil {
+		return ServerInfo{}, err
+	}
+	if !ed25519.Verify(pub.PublicKey, raw.Bytes(), sig) {
+		return ServerInfo{}, ErrInvalidSignature
+	}
+
+	if key != "" && !strings.EqualFold(key, info.Key) {
+		return ServerInfo{}, ErrServerKeyMismatch
+	}
+	if maxAge > 0 {
+		signed, err := time.Parse(TimeFormat8601, info.Timestamp)
+		if err != nil {
+			return ServerInfo{}, fmt.Errorf("Invalid server info timestamp: %w", err)
+		}
+		if time.Since(signed) > maxAge {
+			return ServerInfo{}, ErrServerInfoStale
+		}
+	}
+	return info, nil
+}
+
+// GetServerInfo fetches and verifies the info document of a server, signed
+// within MaxServerInfoAge and (if key is not empty) by the expected key.
+func GetServerInfo(client *http.Client, server *url.URL, key string) (ServerInfo, error) {
+	infoURL := *server
+	infoURL.Path = path.Join(infoURL.Path, WellKnownPath)
+
+	req, err := http.NewRequest("GET", infoURL.String(), nil)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+	req.Header.Set("Spring-Version", SpringVersion)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return ServerInfo{}, fmt.Errorf("Status code: %v", res.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxServerInfoLen))
+	if err != nil {
+		return ServerInfo{}, err
+	}
+	return VerifyServerInfo(data, key, MaxServerInfoAge)
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/royragsdale/s83"
+)
+
+// Result is a board matching a search, scored by how often the search terms
+// appear in its text.
+type Result struct {
+	Board s83.Board
+	Score int
+}
+
+// index is an inverted index of the words in each board's text. It is kept in
+// memory and rebuilt when the store is loaded. Callers must hold the store's
+// lock.
+type index struct {
+	terms map[string]map[string]int // term -> key -> count
+	keys  map[string][]string       // key -> terms (to remove a board)
+}
+
+func newIndex() *index {
+	return &index{terms: map[string]map[string]int{}, keys: map[string][]string{}}
+}
+
+// terms splits text into lower case words of letters and digits
+func terms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// add indexes a board, replacing any board already indexed for its key
+func (idx *index) add(b s83.Board) {
+	idx.remove(b.Key())
+	counts := map[string]int{}
+	for _, term := range terms(s83.BoardText(b.Content)) {
+		counts[term] += 1
+	}
+	keyTerms := make([]string, 0, len(counts))
+	for term, n := range counts {
+		if idx.terms[term] == nil {
+			idx.terms[term] = map[string]int{}
+		}
+		idx.terms[term][b.Key()] = n
+		keyTerms = append(keyTerms, term)
+	}
+	idx.keys[b.Key()] = keyTerms
+}
+
+func (idx *index) remove(key string) {
+	for _, term := range idx.keys[key] {
+		delete(idx.terms[term], key)
+		if len(idx.terms[term]) == 0 {
+			delete(idx.terms, term)
+		}
+	}
+	delete(idx.keys, key)
+}
+
+// search returns the score of every key containing all the terms
+func (idx *index) search(query []string) map[string]int {
+	scores := map[string]int{}
+	for i, term := range query {
+		matches := idx.terms[term]
+		if i == 0 {
+			for key, n := range matches {
+				scores[key] = n
+			}
+			continue
+		}
+		for key := range scores {
+			if n, ok := matches[key]; ok {
+				scores[key] += n
+			} else {
+				delete(scores, key)
+			}
+		}
+	}
+	return scores
+}
+
+// Search finds the boards whose text contains every word in the query (case
+// insensitive). Results are ordered by score, then by the newest board (then
+// by key). A limit of 0 returns every match.
+func (s *Store) Search(query string, limit int) []Result {
+	q := terms(query)
+	if len(q) == 0 {
+		return []Result{}
+	}
+
+	s.mu.RLock()
+	results := []Result{}
+	for key, score := range s.index.search(q) {
+		if b, ok := s.cache[key]; ok {
+			results = append(results, Result{b, score})
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if !a.Board.Time().Equal(b.Board.Time()) {
+			return a.Board.AfterBoard(b.Board)
+		}
+		return a.Board.Key() < b.Board.Key()
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
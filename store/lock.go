@@ -0,0 +1,40 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// locked by a process changing the store (e.g. a running server), so others
+// don't change boards underneath it
+const lockFile = "lock"
+
+// ErrLocked is returned by LockDir when another process holds the lock.
+var ErrLocked = errors.New("store is locked by another process (is the server running?)")
+
+// DirLock is an exclusive lock on a store directory.
+type DirLock struct {
+	f *os.File
+}
+
+// LockDir takes an exclusive lock on a store directory without waiting,
+// returning ErrLocked if another process holds it. Take the lock before
+// loading a store to change it. The lock is held until Unlock is called or the
+// process exits. On platforms without file locks it always succeeds.
+func LockDir(path string) (*DirLock, error) {
+	f, err := os.OpenFile(filepath.Join(path, lockFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := tryLock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &DirLock{f}, nil
+}
+
+// Unlock releases the lock.
+func (l *DirLock) Unlock() error {
+	return l.f.Close() // closing releases the lock
+}
@@ -17,9 +17,15 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/royragsdale/s83"
 )
@@ -27,18 +33,111 @@ import (
 // allow for future variations with different versions
 const ext = ".s83"
 
+// keys that should never be served or accepted, one per line
+const blockListFile = "blocklist"
+
+// keys allowed to publish (e.g. on an invite only server), one per line
+const allowListFile = "allowlist"
+
+// invites that have already been redeemed, one ID per line
+const invitesFile = "invites"
+
+// the latest timestamp seen for each key (kept after boards expire), one
+// "<key> <unix time>" per line. Appended to on every add and compacted on load.
+const latestFile = "latest"
+
+// the sequence number of the last change to each board, one "<seq> <key>" per
+// line ("<seq> <key> removed" for a removal). Appended to on every change and
+// compacted on load, when the next number is kept on a "next <seq>" line.
+const changesFile = "changes"
+
+// boards moved out of the store (e.g. dated far in the future) are kept here
+const quarantineDir = "quarantine"
+
+// ErrInviteUsed is returned when an invite has already been redeemed.
+var ErrInviteUsed = errors.New("invite already used")
+
+// ErrReadOnly is returned when changing a store opened with NewReadOnly.
+var ErrReadOnly = errors.New("store opened read only")
+
+// ErrNotNewer is returned when adding a board that is not newer than the
+// latest board seen for its key.
+var ErrNotNewer = errors.New("not newer than the latest board seen for this key")
+
 type Cache map[string]s83.Board
 
+// Stats counts operations on the store since it was created.
+type Stats struct {
+	Gets        uint64
+	CacheHits   uint64
+	CacheMisses uint64
+	Adds        uint64
+	Removes     uint64
+	Errors      uint64
+}
+
+// Entry is a board numbered by the last change made to it. Numbers only ever
+// increase, so they can be used as a cursor. If the last change removed the
+// board, Removed is set and Board is empty.
+type Entry struct {
+	Seq     uint64
+	Key     string
+	Board   s83.Board
+	Removed bool
+}
+
+// Sources of changes, so change hooks can tell what made them.
+const (
+	SourceAdd        = "add"        // Add (e.g. a board published to the server)
+	SourceMirror     = "mirror"     // AddMirrored (a board copied from another server)
+	SourceRemove     = "remove"     // Remove
+	SourceQuarantine = "quarantine" // Quarantine
+)
+
+// Change describes a board being added to (or removed from) the store. Seq
+// numbers it as Changes does, so it can be used as a cursor.
+type Change struct {
+	Seq     uint64
+	Key     string
+	Board   s83.Board // empty for removals
+	Removed bool
+	Source  string
+}
+
 type Store struct {
+	stats     Stats // first for 64-bit alignment of atomic counters
+	mu        sync.RWMutex
 	dir       string
 	numBoards int
 	cache     Cache
+	blocked   map[string]bool
+	allowed   map[string]bool
+	invites   map[string]bool // redeemed
+	latest    map[string]time.Time
+	latestLog int               // lines in the latest file
+	seq       uint64            // last change number
+	changes   map[string]uint64 // last change number by key
+	removed   map[string]bool   // keys whose last change was a removal
+	changeLog int               // lines in the changes file
+	index     *index
+	hooks     []func(Change)
+	readOnly  bool
 }
 
 // New takes a path to a directory on disk and initializes the backing
 // data structures. In loading the directory it validates any existing boards
 // that are found. NewStore will error if the path provided is not a directory.
 func New(path string) (*Store, error) {
+	return open(path, false)
+}
+
+// NewReadOnly loads a store without writing anything to its directory (e.g. to
+// inspect the store of a running server). Changing it returns ErrReadOnly.
+func NewReadOnly(path string) (*Store, error) {
+	return open(path, true)
+}
+
+func open(path string, readOnly bool) (*Store, error) {
 
 	absPath, err := filepath.Abs(path)
 	if err != nil {
@@ -52,9 +151,34 @@ func New(path string) (*Store, error) {
 		return nil, errors.New(fmt.Sprintf("store path (%s) is not a directory", absPath))
 	}
 
-	store := &Store{absPath, 0, Cache{}}
+	store := &Store{dir: absPath, cache: Cache{}, index: newIndex(), readOnly: readOnly}
+
+	if store.blocked, err = store.loadList(blockListFile); err != nil {
+		return nil, err
+	}
+	if store.allowed, err = store.loadList(allowListFile); err != nil {
+		return nil, err
+	}
+	if store.invites, err = store.loadList(invitesFile); err != nil {
+		return nil, err
+	}
+	if err = store.loadLatest(); err != nil {
+		return nil, err
+	}
 
-	return store, store.validate()
+	if err = store.loadChanges(); err != nil {
+		return nil, err
+	}
+
+	if err = store.validate(); err != nil {
+		return nil, err
+	}
+	if store.latestLog != len(store.latest) && !readOnly {
+		if err = store.saveLatest(); err != nil {
+			return nil, err
+		}
+	}
+	return store, store.numberBoards()
 }
 
 // validate walks the store directory and checks all the boards
@@ -69,8 +193,15 @@ func (s *Store) validate() error {
 		key := strings.TrimSuffix(filepath.Base(boardPath), ext)
 		b, err := s.Get(key)
 		if err == nil {
+			s.mu.Lock()
 			s.numBoards += 1
 			s.cache[key] = b
+			if b.After(s.latest[key]) {
+				// e.g. boards stored before timestamps were recorded
+				s.latest[key] = b.Time()
+				s.latestLog = -1
+			}
+			s.mu.Unlock()
 		}
 	}
 
@@ -84,11 +215,40 @@ func (s *Store) validate() error {
 // store (e.g. the board does not exist) or based on the contents of the file
 // (e.g. it is an invalid board, the signature fails to verify, etc).
 func (s *Store) Get(key string) (s83.Board, error) {
+	atomic.AddUint64(&s.stats.Gets, 1)
+
 	// check cache first
-	if b, ok := s.cache[key]; ok {
+	s.mu.RLock()
+	b, ok := s.cache[key]
+	s.mu.RUnlock()
+	if ok {
+		atomic.AddUint64(&s.stats.CacheHits, 1)
 		return b, nil
 	}
+	atomic.AddUint64(&s.stats.CacheMisses, 1)
+
+	b, err := s.readBoard(key)
+	if err != nil {
+		return b, err
+	}
+
+	// valid board, add to cache unless it changed while reading (e.g. it was
+	// replaced or removed concurrently)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.cache[key]; ok {
+		return cached, nil
+	}
+	if !s.boardExists(b) {
+		return s83.Board{}, os.ErrNotExist
+	}
+	s.cache[key] = b
+	s.index.add(b)
+	return b, nil
+}
 
+// readBoard reads and validates a board from disk
+func (s *Store) readBoard(key string) (s83.Board, error) {
 	data, err := os.ReadFile(s.keyToPath(key))
 	if err != nil {
 		return s83.Board{}, err
@@ -107,56 +267,661 @@ func (s *Store) Get(key string) (s83.Board, error) {
 	content := data[sigEnd+1:]
 
 	// validate on creation
-	b, err := s83.NewBoard(key, sig, content)
-	if err == nil {
-		// valid board, add to cache
-		s.cache[key] = b
-	}
+	return s83.NewBoard(key, sig, content)
+}
 
-	return b, err
+// Verify reads every board file in the store from disk (rather than the cache)
+// and checks it is a validly signed board. It returns the result for each key,
+// nil for valid boards.
+func (s *Store) Verify() (map[string]error, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*"+ext))
+	if err != nil {
+		return nil, err
+	}
+	results := map[string]error{}
+	for _, boardPath := range matches {
+		key := strings.TrimSuffix(filepath.Base(boardPath), ext)
+		_, results[key] = s.readBoard(key)
+	}
+	return results, nil
 }
 
 // TODO: consider a variation that keeps a history of boards.
 
 // Add stores a board to disk. This will clobber any existing boards. This
-// matches the ephemeral nature of the protocol. Any errors opening or writing
-// the backing file will be returned.
+// matches the ephemeral nature of the protocol. A board that is not newer than
+// the latest seen for its key (other than the stored board itself) returns
+// ErrNotNewer; the check and the write happen under the lock, so concurrent
+// updates can't roll a key back. Any errors opening or writing the backing file
+// will be returned.
 func (s *Store) Add(b s83.Board) error {
+	return s.add(b, SourceAdd)
+}
+
+// AddMirrored adds a board copied from another server, like Add, but its
+// change is marked as a mirror (e.g. so it doesn't trigger publish hooks).
+func (s *Store) AddMirrored(b s83.Board) error {
+	return s.add(b, SourceMirror)
+}
+
+func (s *Store) add(b s83.Board, source string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+
+	if latest, ok := s.latest[b.Key()]; ok && !b.After(latest) {
+		if stored, ok := s.cache[b.Key()]; !ok || stored.Signature() != b.Signature() {
+			s.mu.Unlock()
+			return ErrNotNewer
+		}
+	}
 	overwrite := s.boardExists(b)
+	var seq uint64
 	data := append([]byte(b.Signature()+"\n"), b.Content...)
 	err := os.WriteFile(s.boardToPath(b), data, 0600)
-	if err == nil {
-		// successfully saved to disk so update cache
+	atomic.AddUint64(&s.stats.Adds, 1)
+	if err != nil {
+		atomic.AddUint64(&s.stats.Errors, 1)
+	} else {
+		// successfully saved to disk so record it and update cache, as with
+		// Remove a failure to record is counted but the board is still stored
+		if recErr := s.recordLatest(b); recErr != nil {
+			atomic.AddUint64(&s.stats.Errors, 1)
+		}
+		if recErr := s.recordChange(b.Key()); recErr != nil {
+			atomic.AddUint64(&s.stats.Errors, 1)
+		}
+		seq = s.changes[b.Key()]
 		s.cache[b.Key()] = b
+		s.index.add(b)
 
 		if !overwrite {
 			s.numBoards += 1
 		}
 	}
+	s.mu.Unlock()
+
+	if err == nil {
+		s.notify(Change{Seq: seq, Key: b.Key(), Board: b, Source: source})
+	}
 	return err
 }
 
 // Remove deletes a board from disk based on key. If the board does not exist
 // in the store this will return an error.
 func (s *Store) Remove(key string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
 
 	// proactively remove from cache
 	delete(s.cache, key)
+	s.index.remove(key)
 
+	var seq uint64
 	err := os.Remove(s.keyToPath(key))
+	atomic.AddUint64(&s.stats.Removes, 1)
 	if err == nil {
 		s.numBoards -= 1
+		if recErr := s.recordRemoval(key); recErr != nil {
+			atomic.AddUint64(&s.stats.Errors, 1)
+		}
+		seq = s.changes[key]
+	} else if !errors.Is(err, os.ErrNotExist) {
+		atomic.AddUint64(&s.stats.Errors, 1)
+	}
+	s.mu.Unlock()
+
+	if err == nil {
+		s.notify(Change{Seq: seq, Key: key, Removed: true, Source: SourceRemove})
 	}
 	return err
 }
 
+// Quarantine moves every board timestamped after the given time out of the
+// store and into a quarantine directory inside it. Quarantined boards are no
+// longer served, but can be inspected (or restored by moving them back). It
+// returns the keys of the boards moved.
+func (s *Store) Quarantine(after time.Time) ([]string, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	moved := []string{}
+	for _, b := range s.Boards() {
+		if !b.After(after) {
+			continue
+		}
+
+		var seq uint64
+		s.mu.Lock()
+		err := os.MkdirAll(filepath.Join(s.dir, quarantineDir), 0700)
+		if err == nil {
+			err = os.Rename(s.boardToPath(b), filepath.Join(s.dir, quarantineDir, filepath.Base(s.boardToPath(b))))
+		}
+		if err == nil {
+			delete(s.cache, b.Key())
+			s.index.remove(b.Key())
+			s.numBoards -= 1
+			err = s.recordRemoval(b.Key())
+			seq = s.changes[b.Key()]
+		} else {
+			atomic.AddUint64(&s.stats.Errors, 1)
+		}
+		s.mu.Unlock()
+
+		if err != nil {
+			return moved, err
+		}
+		moved = append(moved, b.Key())
+		s.notify(Change{Seq: seq, Key: b.Key(), Removed: true, Source: SourceQuarantine})
+	}
+
+	// forget timestamps from the future too, they would block every update
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	forgot := false
+	for key, ts := range s.latest {
+		if ts.After(after) {
+			delete(s.latest, key)
+			forgot = true
+		}
+	}
+	if forgot {
+		return moved, s.saveLatest()
+	}
+	return moved, nil
+}
+
+// Latest returns the latest timestamp of any board added for a key, even if
+// that board has since expired or been removed.
+func (s *Store) Latest(key string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ts, ok := s.latest[key]
+	return ts, ok
+}
+
+// Changes returns up to limit boards changed (or removed) after the given
+// sequence number, in the order they were changed. The last entry's Seq is the
+// cursor for the next call.
+func (s *Store) Changes(since uint64, limit int) []Entry {
+	s.mu.RLock()
+	entries := []Entry{}
+	for key, seq := range s.changes {
+		if seq > since {
+			entries = append(entries, Entry{seq, key, s.cache[key], s.removed[key]})
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// Seq returns the number of the last change made to the store.
+func (s *Store) Seq() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.seq
+}
+
+// OnChange registers a function to be called after every successful Add or
+// Remove. Functions are called synchronously (without the store locked), in
+// the order they were registered, so they should not block.
+func (s *Store) OnChange(fn func(Change)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, fn)
+}
+
+// Readable checks that the store's directory can still be listed.
+func (s *Store) Readable() error {
+	f, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Readdirnames(1); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Writable checks that boards can be written to the store's directory by
+// creating (and removing) a temporary file.
+func (s *Store) Writable() error {
+	f, err := os.CreateTemp(s.dir, ".writable-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Dir returns the store's (absolute) directory.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
 // Count returns the number of boards currently tracked by the store.
 func (s *Store) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.numBoards
 }
 
+// Stats returns a snapshot of the store's operation counters.
+func (s *Store) Stats() Stats {
+	return Stats{
+		atomic.LoadUint64(&s.stats.Gets),
+		atomic.LoadUint64(&s.stats.CacheHits),
+		atomic.LoadUint64(&s.stats.CacheMisses),
+		atomic.LoadUint64(&s.stats.Adds),
+		atomic.LoadUint64(&s.stats.Removes),
+		atomic.LoadUint64(&s.stats.Errors),
+	}
+}
+
+// Size returns the total bytes of content for every board in the store.
+func (s *Store) Size() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	size := 0
+	for _, b := range s.cache {
+		size += len(b.Content)
+	}
+	return size
+}
+
+// Boards returns every board currently tracked by the store, ordered by key.
+func (s *Store) Boards() []s83.Board {
+	s.mu.RLock()
+	boards := make([]s83.Board, 0, len(s.cache))
+	for _, b := range s.cache {
+		boards = append(boards, b)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(boards, func(i, j int) bool { return boards[i].Key() < boards[j].Key() })
+	return boards
+}
+
+// Block adds a key to the persistent block list. Blocking a key does not
+// remove any board already stored for it.
+func (s *Store) Block(key string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.blocked[key] {
+		return nil
+	}
+	s.blocked[key] = true
+	return s.saveList(blockListFile, s.blocked)
+}
+
+// Unblock removes a key from the persistent block list. Unblocking a key that
+// is not blocked is an error.
+func (s *Store) Unblock(key string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.blocked[key] {
+		return fmt.Errorf("key not blocked: %s", key)
+	}
+	delete(s.blocked, key)
+	return s.saveList(blockListFile, s.blocked)
+}
+
+// Blocked reports whether a key is on the persistent block list.
+func (s *Store) Blocked(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.blocked[key]
+}
+
+// BlockList returns the sorted keys on the persistent block list.
+func (s *Store) BlockList() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedKeys(s.blocked)
+}
+
+// Allow adds a key to the persistent allow list.
+func (s *Store) Allow(key string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.allowed[key] {
+		return nil
+	}
+	s.allowed[key] = true
+	return s.saveList(allowListFile, s.allowed)
+}
+
+// Disallow removes a key from the persistent allow list. Removing a key that
+// is not allowed is an error.
+func (s *Store) Disallow(key string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.allowed[key] {
+		return fmt.Errorf("key not allowed: %s", key)
+	}
+	delete(s.allowed, key)
+	return s.saveList(allowListFile, s.allowed)
+}
+
+// Allowed reports whether a key is on the persistent allow list.
+func (s *Store) Allowed(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.allowed[key]
+}
+
+// AllowList returns the sorted keys on the persistent allow list.
+func (s *Store) AllowList() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedKeys(s.allowed)
+}
+
+// Redeem records a single use invite as used and adds the key that presented
+// it to the allow list. An invite can only be redeemed once (ErrInviteUsed).
+func (s *Store) Redeem(inviteID string, key string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.invites[inviteID] {
+		return ErrInviteUsed
+	}
+	s.invites[inviteID] = true
+	if err := s.saveList(invitesFile, s.invites); err != nil {
+		return err
+	}
+	s.allowed[key] = true
+	return s.saveList(allowListFile, s.allowed)
+}
+
 /* Convenience functions. */
 
+// notify calls every registered change hook.
+func (s *Store) notify(c Change) {
+	s.mu.RLock()
+	hooks := s.hooks
+	s.mu.RUnlock()
+
+	for _, fn := range hooks {
+		fn(c)
+	}
+}
+
+// loadList reads a list of keys (if any) from a file in the store directory.
+// Blank lines and lines starting with '#' are ignored.
+func (s *Store) loadList(name string) (map[string]bool, error) {
+	keys := map[string]bool{}
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		keys[line] = true
+	}
+	return keys, nil
+}
+
+// saveList writes a list of keys to disk, callers must hold the lock.
+func (s *Store) saveList(name string, set map[string]bool) error {
+	keys := sortedKeys(set)
+	data := strings.Join(keys, "\n")
+	if len(keys) > 0 {
+		data += "\n"
+	}
+	return os.WriteFile(filepath.Join(s.dir, name), []byte(data), 0600)
+}
+
+// loadLatest reads the latest timestamps (if any), keeping the latest for
+// each key.
+func (s *Store) loadLatest() error {
+	s.latest = map[string]time.Time{}
+	data, err := os.ReadFile(filepath.Join(s.dir, latestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		s.latestLog += 1
+		unix, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if ts := time.Unix(unix, 0).UTC(); ts.After(s.latest[fields[0]]) {
+			s.latest[fields[0]] = ts
+		}
+	}
+	return nil
+}
+
+// saveLatest rewrites the latest timestamps with one line per key, callers
+// must hold the lock (or be loading the store).
+func (s *Store) saveLatest() error {
+	keys := make([]string, 0, len(s.latest))
+	for key := range s.latest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var data strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&data, "%s %d\n", key, s.latest[key].Unix())
+	}
+	s.latestLog = len(s.latest)
+	return os.WriteFile(filepath.Join(s.dir, latestFile), []byte(data.String()), 0600)
+}
+
+// recordLatest appends a board's timestamp if it is the latest for its key,
+// callers must hold the lock.
+func (s *Store) recordLatest(b s83.Board) error {
+	if !b.After(s.latest[b.Key()]) {
+		return nil
+	}
+	s.latest[b.Key()] = b.Time()
+
+	// compact once superseded lines dominate the file
+	if s.latestLog > 2*len(s.latest)+100 {
+		return s.saveLatest()
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, latestFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s %d\n", b.Key(), b.Time().Unix()); err != nil {
+		f.Close()
+		return err
+	}
+	s.latestLog += 1
+	return f.Close()
+}
+
+// loadChanges reads the sequence numbers (if any), keeping the last for each
+// key.
+func (s *Store) loadChanges() error {
+	s.changes = map[string]uint64{}
+	s.removed = map[string]bool{}
+	data, err := os.ReadFile(filepath.Join(s.dir, changesFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 && len(fields) != 3 {
+			continue
+		}
+		s.changeLog += 1
+		if fields[0] == "next" {
+			if next, err := strconv.ParseUint(fields[1], 10, 64); err == nil && next > s.seq {
+				s.seq = next - 1
+			}
+			continue
+		}
+		seq, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if seq > s.changes[fields[1]] {
+			s.changes[fields[1]] = seq
+			s.removed[fields[1]] = len(fields) == 3 && fields[2] == "removed"
+		}
+		if seq > s.seq {
+			s.seq = seq
+		}
+	}
+	return nil
+}
+
+// numberBoards drops the numbers of boards no longer stored (unless recorded
+// as removed) and numbers any boards without one (e.g. stored before changes
+// were numbered), oldest first. It rewrites the changes file if anything
+// changed.
+func (s *Store) numberBoards() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.changes {
+		_, stored := s.cache[key]
+		if stored == s.removed[key] {
+			delete(s.changes, key)
+			delete(s.removed, key)
+		}
+	}
+	unnumbered := []s83.Board{}
+	for key, b := range s.cache {
+		if _, ok := s.changes[key]; !ok {
+			unnumbered = append(unnumbered, b)
+		}
+	}
+	sort.Slice(unnumbered, func(i, j int) bool {
+		if unnumbered[i].Time().Equal(unnumbered[j].Time()) {
+			return unnumbered[i].Key() < unnumbered[j].Key()
+		}
+		return unnumbered[j].AfterBoard(unnumbered[i])
+	})
+	for _, b := range unnumbered {
+		s.seq += 1
+		s.changes[b.Key()] = s.seq
+	}
+
+	if s.readOnly {
+		return nil
+	}
+	if len(unnumbered) > 0 || s.changeLog > len(s.changes)+1 {
+		return s.saveChanges()
+	}
+	return nil
+}
+
+// saveChanges rewrites the changes file with one line per board, callers must
+// hold the lock.
+func (s *Store) saveChanges() error {
+	keys := make([]string, 0, len(s.changes))
+	for key := range s.changes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return s.changes[keys[i]] < s.changes[keys[j]] })
+
+	var data strings.Builder
+	fmt.Fprintf(&data, "next %d\n", s.seq+1)
+	for _, key := range keys {
+		fmt.Fprint(&data, changeLine(s.changes[key], key, s.removed[key]))
+	}
+	s.changeLog = len(s.changes) + 1
+	return os.WriteFile(filepath.Join(s.dir, changesFile), []byte(data.String()), 0600)
+}
+
+// recordChange numbers a change to a board, callers must hold the lock.
+func (s *Store) recordChange(key string) error {
+	return s.logChange(key, false)
+}
+
+// recordRemoval numbers the removal of a board, so it is listed by Changes,
+// callers must hold the lock.
+func (s *Store) recordRemoval(key string) error {
+	return s.logChange(key, true)
+}
+
+func (s *Store) logChange(key string, removed bool) error {
+	s.seq += 1
+	s.changes[key] = s.seq
+	s.removed[key] = removed
+
+	// compact once superseded lines dominate the file
+	if s.changeLog > 2*len(s.changes)+100 {
+		return s.saveChanges()
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, changesFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprint(f, changeLine(s.seq, key, removed)); err != nil {
+		f.Close()
+		return err
+	}
+	s.changeLog += 1
+	return f.Close()
+}
+
+func changeLine(seq uint64, key string, removed bool) string {
+	if removed {
+		return fmt.Sprintf("%d %s removed\n", seq, key)
+	}
+	return fmt.Sprintf("%d %s\n", seq, key)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (s *Store) boardExists(b s83.Board) bool {
 	_, err := os.Stat(s.boardToPath(b))
 	return !errors.Is(err, os.ErrNotExist)
@@ -1,9 +1,12 @@
 package store
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/royragsdale/s83"
 )
@@ -15,6 +18,20 @@ func emptyTestStore(t *testing.T) (*Store, error) {
 	return New(dir)
 }
 
+// boardAt signs content dated ts with a key repeating seed
+func boardAt(t *testing.T, seed string, content string, ts time.Time) s83.Board {
+	t.Helper()
+	c, err := s83.NewCreatorFromKey(strings.Repeat(seed, s83.KeyLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.NewBoard([]byte(fmt.Sprintf(`<time datetime="%s"></time>%s`, ts.UTC().Format(s83.TimeFormat8601), content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
 func testBoard(content []byte) (s83.Board, error) {
 	c, err := s83.NewCreatorFromKey(s83.TestPrivate)
 	if err != nil {
@@ -247,3 +264,398 @@ func TestCache(t *testing.T) {
 	}
 
 }
+
+func TestBlockList(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatalf(`error creating empty store:%v`, err)
+	}
+
+	key := s83.InfernalKey
+	if store.Blocked(key) {
+		t.Errorf("empty store should not block anything")
+	}
+
+	if err = store.Unblock(key); err == nil {
+		t.Errorf("unblocking a key that is not blocked should error")
+	}
+
+	if err = store.Block(key); err != nil {
+		t.Fatalf("error blocking key: %v", err)
+	}
+
+	// reload store to ensure the block list persists
+	store, err = New(dir)
+	if err != nil {
+		t.Fatalf(`error reloading store:%v`, err)
+	}
+	if !store.Blocked(key) || len(store.BlockList()) != 1 {
+		t.Errorf("block list did not persist: %v", store.BlockList())
+	}
+
+	if err = store.Unblock(key); err != nil {
+		t.Errorf("error unblocking key: %v", err)
+	}
+	if store.Blocked(key) {
+		t.Errorf("key should no longer be blocked")
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	store, err := emptyTestStore(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := testBoard(testBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := []Change{}
+	store.OnChange(func(c Change) { changes = append(changes, c) })
+
+	store.Add(b)
+	store.Remove(b.Key())
+	// removing a missing board is not a change
+	store.Remove(b.Key())
+
+	if len(changes) != 2 {
+		t.Fatalf("expected an add and a remove: %+v", changes)
+	}
+	if changes[0].Removed || !changes[0].Board.Eq(b) || changes[0].Key != b.Key() || changes[0].Source != SourceAdd {
+		t.Errorf("unexpected add: %+v", changes[0])
+	}
+	if !changes[1].Removed || changes[1].Key != b.Key() || changes[1].Source != SourceRemove {
+		t.Errorf("unexpected remove: %+v", changes[1])
+	}
+}
+
+func TestAllowListAndInvites(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatalf(`error creating empty store:%v`, err)
+	}
+
+	key := s83.TestPublic
+	if err = store.Disallow(key); err == nil {
+		t.Errorf("disallowing a key that is not allowed should error")
+	}
+
+	if err = store.Redeem("invite", key); err != nil {
+		t.Fatalf("error redeeming invite: %v", err)
+	}
+	if err = store.Redeem("invite", s83.InfernalKey); err != ErrInviteUsed {
+		t.Errorf("an invite should only be redeemed once: %v", err)
+	}
+
+	// reload store to ensure the allow list and used invites persist
+	store, err = New(dir)
+	if err != nil {
+		t.Fatalf(`error reloading store:%v`, err)
+	}
+	if !store.Allowed(key) || len(store.AllowList()) != 1 {
+		t.Errorf("allow list did not persist: %v", store.AllowList())
+	}
+	if err = store.Redeem("invite", s83.InfernalKey); err != ErrInviteUsed {
+		t.Errorf("used invites did not persist: %v", err)
+	}
+
+	if err = store.Disallow(key); err != nil || store.Allowed(key) {
+		t.Errorf("key should no longer be allowed: %v", err)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := testBoard(testBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// boards timestamped before the cutoff stay
+	if moved, err := store.Quarantine(b.Time().Add(time.Hour)); err != nil || len(moved) != 0 {
+		t.Errorf("nothing should be quarantined: %v %v", moved, err)
+	}
+
+	moved, err := store.Quarantine(b.Time().Add(-time.Hour))
+	if err != nil || len(moved) != 1 || moved[0] != b.Key() {
+		t.Fatalf("board should be quarantined: %v %v", moved, err)
+	}
+	if store.Count() != 0 {
+		t.Errorf("quarantined boards should not be counted: %d", store.Count())
+	}
+	if _, err := os.Stat(filepath.Join(dir, quarantineDir, b.Key()+ext)); err != nil {
+		t.Errorf("quarantined board should be kept: %v", err)
+	}
+
+	// and are not loaded again
+	reloaded, err := New(dir)
+	if err != nil || reloaded.Count() != 0 {
+		t.Errorf("quarantined boards should not be loaded: %d %v", reloaded.Count(), err)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := testBoard(testBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Latest(b.Key()); ok {
+		t.Errorf("no timestamp should be recorded before a board is added")
+	}
+	if err := store.Add(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Remove(b.Key()); err != nil {
+		t.Fatal(err)
+	}
+
+	// kept after the board is removed, and across restarts
+	for i, s := range []*Store{store, nil} {
+		if s == nil {
+			if s, err = New(dir); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if ts, ok := s.Latest(b.Key()); !ok || !ts.Equal(b.Time()) {
+			t.Errorf("%d: latest timestamp should be kept: %v %v want %v", i, ts, ok, b.Time())
+		}
+	}
+
+	// compacted to one line per key on load
+	if err := os.WriteFile(filepath.Join(dir, latestFile), []byte("x 1\nx 3\nx 2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts, _ := reloaded.Latest("x"); ts.Unix() != 3 {
+		t.Errorf("the latest timestamp should win: %v", ts)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, latestFile)); string(data) != "x 3\n" {
+		t.Errorf("latest file should be compacted: %q", data)
+	}
+
+	// nothing is recorded for a board that fails to be written
+	failed, err := emptyTestStore(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(failed.keyToPath(b.Key()), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := failed.Add(b); err == nil {
+		t.Fatal("adding over a directory should fail")
+	}
+	if ts, ok := failed.Latest(b.Key()); ok {
+		t.Errorf("no timestamp should be recorded for a failed write: %v", ts)
+	}
+	if changes := failed.Changes(0, 10); len(changes) != 0 || failed.Seq() != 0 {
+		t.Errorf("no change should be recorded for a failed write: %v", changes)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boards := []s83.Board{}
+	hourAgo := time.Now().Add(-time.Hour)
+	for i, seed := range []string{"1", "2", "1"} {
+		b := boardAt(t, seed, "<p>changed</p>", hourAgo.Add(time.Duration(i)*time.Minute))
+		if err := store.Add(b); err != nil {
+			t.Fatal(err)
+		}
+		boards = append(boards, b)
+	}
+
+	check := func(s *Store, since uint64, limit int, want ...uint64) {
+		t.Helper()
+		entries := s.Changes(since, limit)
+		got := []uint64{}
+		for _, e := range entries {
+			got = append(got, e.Seq)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("changes since %d: got %v want %v", since, got, want)
+		}
+	}
+
+	// only the last change to a board is listed
+	check(store, 0, 10, 2, 3)
+	check(store, 2, 10, 3)
+	check(store, 0, 1, 2)
+	if e := store.Changes(2, 10); e[0].Board.Key() != boards[2].Key() {
+		t.Errorf("wrong board for change: %s", e[0].Board.Key())
+	}
+
+	// removals are changes too, numbers survive restarts
+	if err := store.Remove(boards[2].Key()); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(reloaded, 0, 10, 2, 4)
+	if e := reloaded.Changes(3, 10); !e[0].Removed || e[0].Key != boards[2].Key() {
+		t.Errorf("removal should be listed: %+v", e[0])
+	}
+	if reloaded.Seq() != 4 {
+		t.Errorf("last change number should be kept: %d", reloaded.Seq())
+	}
+	if err := reloaded.Add(boards[2]); err != ErrNotNewer {
+		t.Errorf("re-adding a removed board: got %v want %v", err, ErrNotNewer)
+	}
+	if err := reloaded.Add(boardAt(t, "1", "<p>again</p>", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	check(reloaded, 0, 10, 2, 5)
+	if e := reloaded.Changes(4, 10); e[0].Removed {
+		t.Errorf("board added again should not be removed: %+v", e[0])
+	}
+}
+
+func TestSearch(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	add := func(seed string, content string, ts time.Time) s83.Board {
+		t.Helper()
+		b := boardAt(t, seed, content, ts)
+		if err := store.Add(b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	check := func(s *Store, query string, want ...s83.Board) {
+		t.Helper()
+		results := s.Search(query, 0)
+		got := []string{}
+		for _, r := range results {
+			got = append(got, r.Board.Key())
+		}
+		keys := []string{}
+		for _, b := range want {
+			keys = append(keys, b.Key())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(keys) {
+			t.Errorf("search %q: got %v want %v", query, got, keys)
+		}
+	}
+
+	hourAgo := time.Now().Add(-time.Hour)
+	one := add("1", "<h1>Gardening</h1><p>Tomatoes and more tomatoes.</p>", hourAgo)
+	two := add("2", "<p>Tomato soup, <i>gardening</i> notes</p><style>.soup { }</style>", hourAgo.Add(time.Minute))
+
+	check(store, "TOMATOES", one)
+	// equal scores, newest first
+	check(store, "gardening", two, one)
+	check(store, "gardening soup", two)
+	check(store, "soup tomatoes")
+	check(store, "")
+
+	// updates and removals are indexed
+	two = add("2", "<p>Tomatoes, tomatoes, tomatoes</p>", time.Now())
+	check(store, "tomatoes", two, one)
+	check(store, "soup")
+	if err := store.Remove(one.Key()); err != nil {
+		t.Fatal(err)
+	}
+	check(store, "tomatoes", two)
+
+	// rebuilt when loaded
+	reloaded, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(reloaded, "tomatoes", two)
+	if results := reloaded.Search("tomatoes", 1); len(results) != 1 || results[0].Score != 3 {
+		t.Errorf("limited search: got %v", results)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := testBoard(testBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(b); err != nil {
+		t.Fatal(err)
+	}
+	// an invalid board is skipped when loading, but found by Verify
+	bad := filepath.Join(dir, strings.Repeat("0", s83.KeyLen)+ext)
+	if err := os.WriteFile(bad, []byte("not a board"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ro, err := NewReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ro.Get(b.Key()); err != nil {
+		t.Errorf("read only store should load boards: %v", err)
+	}
+	if err := ro.Remove(b.Key()); err != ErrReadOnly {
+		t.Errorf("read only remove: got %v want %v", err, ErrReadOnly)
+	}
+	if err := ro.Block(b.Key()); err != ErrReadOnly {
+		t.Errorf("read only block: got %v want %v", err, ErrReadOnly)
+	}
+
+	results, err := ro.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[b.Key()] != nil || results[strings.Repeat("0", s83.KeyLen)] == nil {
+		t.Errorf("verify: got %v", results)
+	}
+}
+
+func TestLockDir(t *testing.T) {
+	dir := t.TempDir()
+	lock, err := LockDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LockDir(dir); err != ErrLocked {
+		t.Errorf("second lock: got %v want %v", err, ErrLocked)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = LockDir(dir)
+	if err != nil {
+		t.Errorf("lock after unlock: %v", err)
+	} else {
+		lock.Unlock()
+	}
+}